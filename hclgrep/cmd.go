@@ -3,9 +3,12 @@ package hclgrep
 import (
 	"flag"
 	"fmt"
+	"os"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
@@ -20,6 +23,10 @@ const (
 	CmdNameRx                    = "rx"
 	CmdNameParent                = "p"
 	CmdNameWrite                 = "w"
+	CmdNameSubst                 = "s"
+	CmdNameDelete                = "delete"
+	CmdNameInsertBody            = "insert-body"
+	CmdNameAppend                = "append"
 )
 
 type Cmd struct {
@@ -34,7 +41,9 @@ type CmdValue interface {
 
 type CmdValueRx struct {
 	name string
-	rx   regexp.Regexp
+	// either empty, rxSelectorKey or rxSelectorValue
+	selector string
+	rx       regexp.Regexp
 }
 
 func (v CmdValueRx) Value() interface{} { return v }
@@ -49,9 +58,9 @@ type CmdValueLevel int
 
 func (v CmdValueLevel) Value() interface{} { return v }
 
-type CmdValueString string
+type CmdValueNames []string
 
-func (v CmdValueString) Value() interface{} { return v }
+func (v CmdValueNames) Value() interface{} { return v }
 
 type strCmdFlag struct {
 	name CmdName
@@ -64,6 +73,32 @@ func (o *strCmdFlag) Set(val string) error {
 	return nil
 }
 
+type boolCmdFlag struct {
+	name CmdName
+	cmds *[]Cmd
+}
+
+func (o *boolCmdFlag) String() string   { return "" }
+func (o *boolCmdFlag) IsBoolFlag() bool { return true }
+func (o *boolCmdFlag) Set(val string) error {
+	v, err := strconv.ParseBool(val)
+	if err != nil {
+		return err
+	}
+	if v {
+		*o.cmds = append(*o.cmds, Cmd{name: o.name})
+	}
+	return nil
+}
+
+type stringsFlag []string
+
+func (o *stringsFlag) String() string { return strings.Join(*o, ",") }
+func (o *stringsFlag) Set(val string) error {
+	*o = append(*o, val)
+	return nil
+}
+
 func ParseArgs(args []string) ([]Option, []string, error) {
 	flagSet := flag.NewFlagSet("hclgrep", flag.ContinueOnError)
 	flagSet.Usage = usage
@@ -71,6 +106,59 @@ func ParseArgs(args []string) ([]Option, []string, error) {
 	var prefix bool
 	flagSet.BoolVar(&prefix, "H", false, "prefix filename and byte offset for a match")
 
+	var lineNumber, vimgrep bool
+	flagSet.BoolVar(&lineNumber, "n", false, "prefix filename, line and column for a match on the same line")
+	flagSet.BoolVar(&vimgrep, "vimgrep", false, "output each line of a match prefixed with filename, line and column")
+
+	var jsonOutput bool
+	flagSet.BoolVar(&jsonOutput, "json", false, "output each match as a JSON object")
+
+	var includes, excludes stringsFlag
+	flagSet.Var(&includes, "include", "only search files matching the glob when walking directories")
+	flagSet.Var(&excludes, "exclude", "skip files and directories matching the glob when walking directories")
+
+	var hidden, noIgnore bool
+	flagSet.BoolVar(&hidden, "hidden", false, "search hidden files and directories when walking directories")
+	flagSet.BoolVar(&noIgnore, "no-ignore", false, "don't respect the ignore files when walking directories")
+
+	var jobs int
+	flagSet.IntVar(&jobs, "j", 1, "number of files to process concurrently")
+
+	var rewriteWrite, rewriteDiff bool
+	flagSet.BoolVar(&rewriteWrite, "write", false, "modify the files in place for the rewriting commands")
+	flagSet.BoolVar(&rewriteDiff, "diff", false, "output the diff for the rewriting commands")
+
+	var format string
+	flagSet.StringVar(&format, "format", "", "output each match with the Go template")
+
+	var color string
+	flagSet.StringVar(&color, "color", "auto", "color the output: auto, always or never")
+
+	var heading bool
+	flagSet.BoolVar(&heading, "heading", false, "output the breadcrumb path of the ancestor blocks above each match")
+
+	var before, after, context int
+	flagSet.IntVar(&after, "A", 0, "number of lines to output after each match")
+	flagSet.IntVar(&before, "B", 0, "number of lines to output before each match")
+	flagSet.IntVar(&context, "C", 0, "number of lines to output before and after each match")
+
+	var count, filesWithMatches, filesWithoutMatches bool
+	flagSet.BoolVar(&count, "c", false, "output the number of matches of each file")
+	flagSet.BoolVar(&filesWithMatches, "l", false, "output the names of the files with matches")
+	flagSet.BoolVar(&filesWithoutMatches, "L", false, "output the names of the files without matches")
+
+	var strict bool
+	flagSet.BoolVar(&strict, "strict", false, "stop at the first file that can't be parsed")
+
+	var lenient bool
+	flagSet.BoolVar(&lenient, "lenient", false, "match the body recovered from the files with syntax errors")
+
+	var unordered bool
+	flagSet.BoolVar(&unordered, "unordered", false, "match the attributes/blocks of the bodies in any order")
+
+	var exts string
+	flagSet.StringVar(&exts, "ext", "", "comma separated file extensions to search when walking directories")
+
 	var cmds []Cmd
 	flagSet.Var(&strCmdFlag{
 		name: CmdNameMatch,
@@ -96,11 +184,34 @@ func ParseArgs(args []string) ([]Option, []string, error) {
 		name: CmdNameWrite,
 		cmds: &cmds,
 	}, string(CmdNameWrite), "")
+	flagSet.Var(&strCmdFlag{
+		name: CmdNameSubst,
+		cmds: &cmds,
+	}, string(CmdNameSubst), "")
+	flagSet.Var(&boolCmdFlag{
+		name: CmdNameDelete,
+		cmds: &cmds,
+	}, string(CmdNameDelete), "")
+	flagSet.Var(&strCmdFlag{
+		name: CmdNameInsertBody,
+		cmds: &cmds,
+	}, string(CmdNameInsertBody), "")
+	flagSet.Var(&strCmdFlag{
+		name: CmdNameAppend,
+		cmds: &cmds,
+	}, string(CmdNameAppend), "")
 
 	if err := flagSet.Parse(args); err != nil {
 		return nil, nil, err
 	}
 
+	if jobs < 0 {
+		return nil, nil, fmt.Errorf("the number follows `-j` must >=0, got %d", jobs)
+	}
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+
 	if len(cmds) < 1 {
 		return nil, nil, fmt.Errorf("need at least one command")
 	}
@@ -108,16 +219,35 @@ func ParseArgs(args []string) ([]Option, []string, error) {
 	for i, cmd := range cmds {
 		switch cmd.name {
 		case CmdNameWrite:
+			var names CmdValueNames
+			for _, name := range strings.Split(cmd.src, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names = append(names, name)
+				}
+			}
+			if len(names) == 0 {
+				return nil, nil, fmt.Errorf("`-%s` needs at least one wildcard name", cmd.name)
+			}
+			cmds[i].value = names
+		case CmdNameSubst, CmdNameInsertBody, CmdNameAppend:
+			if i != len(cmds)-1 {
+				return nil, nil, fmt.Errorf("`-%s` must be the last command", cmd.name)
+			}
+			tmpl, err := parseTemplate(cmd.src)
+			if err != nil {
+				return nil, nil, err
+			}
+			cmds[i].value = tmpl
+		case CmdNameDelete:
 			if i != len(cmds)-1 {
 				return nil, nil, fmt.Errorf("`-%s` must be the last command", cmd.name)
 			}
-			cmds[i].value = CmdValueString(cmd.src)
 		case CmdNameRx:
-			name, rx, err := parseRegexpAttr(cmd.src)
+			name, selector, rx, err := parseRegexpAttr(cmd.src)
 			if err != nil {
 				return nil, nil, err
 			}
-			cmds[i].value = CmdValueRx{name: name, rx: *rx}
+			cmds[i].value = CmdValueRx{name: name, selector: selector, rx: *rx}
 		case CmdNameParent:
 			n, err := strconv.Atoi(cmd.src)
 			if err != nil {
@@ -136,13 +266,199 @@ func ParseArgs(args []string) ([]Option, []string, error) {
 		}
 	}
 
-	opts := []Option{OptionPrefixPosition(prefix)}
+	if (rewriteWrite || rewriteDiff) && !isRewriteCmd(cmds[len(cmds)-1].name) {
+		return nil, nil, fmt.Errorf("`-write` and `-diff` can only be used with a rewriting command")
+	}
+
+	// A -w in the middle of the commands prints the values, which can't be mixed with the rewritten files
+	var hasWrite bool
+	for _, cmd := range cmds {
+		if cmd.name == CmdNameWrite {
+			hasWrite = true
+		}
+	}
+	if lastCmd := cmds[len(cmds)-1]; hasWrite && isRewriteCmd(lastCmd.name) {
+		return nil, nil, fmt.Errorf("`-%s` can't be used with `-%s`", CmdNameWrite, lastCmd.name)
+	}
+
+	if before < 0 || after < 0 || context < 0 {
+		return nil, nil, fmt.Errorf("the numbers follow `-A`, `-B` and `-C` must >=0")
+	}
+	// -A and -B take precedence over -C
+	setFlags := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if !setFlags["A"] {
+		after = context
+	}
+	if !setFlags["B"] {
+		before = context
+	}
+	if before > 0 || after > 0 {
+		if jsonOutput {
+			return nil, nil, fmt.Errorf("`-A`, `-B` and `-C` can't be used with `-json`")
+		}
+		if vimgrep {
+			return nil, nil, fmt.Errorf("`-A`, `-B` and `-C` can't be used with `-vimgrep`")
+		}
+		if heading {
+			return nil, nil, fmt.Errorf("`-A`, `-B` and `-C` can't be used with `-heading`")
+		}
+	}
+	if heading && vimgrep {
+		return nil, nil, fmt.Errorf("`-heading` can't be used with `-vimgrep`")
+	}
+
+	var formatTmpl *template.Template
+	if format != "" {
+		switch {
+		case jsonOutput:
+			return nil, nil, fmt.Errorf("`-format` can't be used with `-json`")
+		case vimgrep:
+			return nil, nil, fmt.Errorf("`-format` can't be used with `-vimgrep`")
+		case heading:
+			return nil, nil, fmt.Errorf("`-format` can't be used with `-heading`")
+		case prefix:
+			return nil, nil, fmt.Errorf("`-format` can't be used with `-H`")
+		case lineNumber:
+			return nil, nil, fmt.Errorf("`-format` can't be used with `-n`")
+		case before > 0 || after > 0:
+			return nil, nil, fmt.Errorf("`-format` can't be used with `-A`, `-B` and `-C`")
+		}
+		var err error
+		formatTmpl, err = template.New("format").Option("missingkey=zero").Parse(format)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse format: %w", err)
+		}
+	}
+
+	var colorEnabled bool
+	switch color {
+	case "always":
+		colorEnabled = true
+	case "never":
+	case "auto":
+		colorEnabled = isTerminal(os.Stdout)
+	default:
+		return nil, nil, fmt.Errorf("the value of `-color` must be one of auto, always and never, got %q", color)
+	}
+
+	var prefixFlags []string
+	if prefix {
+		prefixFlags = append(prefixFlags, "`-H`")
+	}
+	if lineNumber {
+		prefixFlags = append(prefixFlags, "`-n`")
+	}
+	if vimgrep {
+		prefixFlags = append(prefixFlags, "`-vimgrep`")
+	}
+	if len(prefixFlags) > 1 {
+		return nil, nil, fmt.Errorf("%s can't be used together", strings.Join(prefixFlags, " and "))
+	}
+	if len(prefixFlags) == 1 && jsonOutput {
+		return nil, nil, fmt.Errorf("%s can't be used with `-json`", prefixFlags[0])
+	}
+
+	var summaryFlags []string
+	if count {
+		summaryFlags = append(summaryFlags, "`-c`")
+	}
+	if filesWithMatches {
+		summaryFlags = append(summaryFlags, "`-l`")
+	}
+	if filesWithoutMatches {
+		summaryFlags = append(summaryFlags, "`-L`")
+	}
+	if len(summaryFlags) > 1 {
+		return nil, nil, fmt.Errorf("%s can't be used together", strings.Join(summaryFlags, " and "))
+	}
+	if len(summaryFlags) == 1 {
+		if jsonOutput {
+			return nil, nil, fmt.Errorf("%s can't be used with `-json`", summaryFlags[0])
+		}
+		if lastCmd := cmds[len(cmds)-1]; isRewriteCmd(lastCmd.name) {
+			return nil, nil, fmt.Errorf("%s can't be used with `-%s`", summaryFlags[0], lastCmd.name)
+		}
+		if hasWrite {
+			return nil, nil, fmt.Errorf("%s can't be used with `-%s`", summaryFlags[0], CmdNameWrite)
+		}
+	}
+
+	// The rewritten files and the values printed by a terminal -w are output as is, without the output format of the
+	// matches
+	if lastCmd := cmds[len(cmds)-1]; lastCmd.name == CmdNameWrite || isRewriteCmd(lastCmd.name) {
+		var formatFlags []string
+		if jsonOutput && lastCmd.name != CmdNameWrite {
+			formatFlags = append(formatFlags, "`-json`")
+		}
+		formatFlags = append(formatFlags, prefixFlags...)
+		if before > 0 || after > 0 {
+			formatFlags = append(formatFlags, "`-A`, `-B` and `-C`")
+		}
+		if heading {
+			formatFlags = append(formatFlags, "`-heading`")
+		}
+		if format != "" {
+			formatFlags = append(formatFlags, "`-format`")
+		}
+		if len(formatFlags) != 0 {
+			return nil, nil, fmt.Errorf("%s can't be used with `-%s`", formatFlags[0], lastCmd.name)
+		}
+	}
+
+	opts := []Option{
+		OptionPrefixPosition(prefix),
+		OptionLineNumber(lineNumber),
+		OptionVimgrep(vimgrep),
+		OptionJSON(jsonOutput),
+		OptionIncludes(includes),
+		OptionExcludes(excludes),
+		OptionHidden(hidden),
+		OptionNoIgnore(noIgnore),
+		OptionJobs(jobs),
+		OptionWrite(rewriteWrite),
+		OptionDiff(rewriteDiff),
+		OptionCount(count),
+		OptionFilesWithMatches(filesWithMatches),
+		OptionFilesWithoutMatches(filesWithoutMatches),
+		OptionStrict(strict),
+		OptionLenient(lenient),
+		OptionUnordered(unordered),
+		OptionContext(before, after),
+		OptionHeading(heading),
+		OptionColor(colorEnabled),
+		OptionFormat(formatTmpl),
+	}
+	if exts != "" {
+		opts = append(opts, OptionExtensions(parseExtensions(exts)))
+	}
 	for _, cmd := range cmds {
 		opts = append(opts, OptionCmd(cmd))
 	}
 	return opts, flagSet.Args(), nil
 }
 
+// isTerminal tells whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func parseExtensions(exts string) []string {
+	var out []string
+	for _, ext := range strings.Split(exts, ",") {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		out = append(out, ext)
+	}
+	return out
+}
+
 func parseAttr(attr string) (string, string, error) {
 	tokens, diags := hclsyntax.LexExpression([]byte(attr), "", hcl.InitialPos)
 	if diags.HasErrors() {
@@ -158,6 +474,15 @@ func parseAttr(attr string) (string, string, error) {
 		return "", "", fmt.Errorf("%v: attribute must starts with an ident, got %q", tok.Range, tok.Type)
 	}
 	name := string(tok.Bytes)
+	// The name can be followed by a selector, e.g. "name.key"
+	if tokens[0].Type == hclsyntax.TokenDot {
+		next()
+		tok := next()
+		if tok.Type != hclsyntax.TokenIdent {
+			return "", "", fmt.Errorf("%v: attribute name selector must be an ident, got %q", tok.Range, tok.Type)
+		}
+		name += "." + string(tok.Bytes)
+	}
 	if tok := next(); tok.Type != hclsyntax.TokenEqual {
 		return "", "", fmt.Errorf(`%v: attribute name must be followed by "=", got %q`, tok.Range, tok.Type)
 	}
@@ -178,10 +503,23 @@ func parseAttr(attr string) (string, string, error) {
 	return name, value, nil
 }
 
-func parseRegexpAttr(attr string) (string, *regexp.Regexp, error) {
+// Selectors of the "-rx" command to filter on either side of an object item (or an attribute).
+const (
+	rxSelectorKey   = "key"
+	rxSelectorValue = "value"
+)
+
+func parseRegexpAttr(attr string) (string, string, *regexp.Regexp, error) {
 	name, value, err := parseAttr(attr)
 	if err != nil {
-		return "", nil, fmt.Errorf("cannot parse attribute: %v", err)
+		return "", "", nil, fmt.Errorf("cannot parse attribute: %v", err)
+	}
+	var selector string
+	if i := strings.Index(name, "."); i != -1 {
+		name, selector = name[:i], name[i+1:]
+		if selector != rxSelectorKey && selector != rxSelectorValue {
+			return "", "", nil, fmt.Errorf("cannot parse attribute: unknown selector %q, must be %q or %q", selector, rxSelectorKey, rxSelectorValue)
+		}
 	}
 	if !strings.HasPrefix(value, "^") {
 		value = "^" + value
@@ -190,5 +528,5 @@ func parseRegexpAttr(attr string) (string, *regexp.Regexp, error) {
 		value = value + "$"
 	}
 	rx, err := regexp.Compile(value)
-	return name, rx, err
+	return name, selector, rx, err
 }
@@ -0,0 +1,102 @@
+package hclgrep
+
+import (
+	"sort"
+	"strings"
+)
+
+// The SGR parameters of the colors used in the output.
+const (
+	colorPrefix    = "35"   // magenta
+	colorSeparator = "36"   // cyan
+	colorMatch     = "1;31" // bold red
+)
+
+// captureColors are the colors of the captured wildcard values, which are assigned to the wildcard names in order.
+var captureColors = []string{
+	"1;32", // bold green
+	"1;33", // bold yellow
+	"1;34", // bold blue
+	"1;36", // bold cyan
+}
+
+// paint colors the text, if color is enabled.
+func (m *Matcher) paint(color, text string) string {
+	if !m.color || text == "" {
+		return text
+	}
+	return "\x1b[" + color + "m" + text + "\x1b[0m"
+}
+
+// colorSpan is a range of bytes in the source to be colored.
+type colorSpan struct {
+	start, end int
+	color      string
+}
+
+// highlight returns the source between the offsets, where the ranges of the matches and their captured wildcard
+// values are colored, if color is enabled.
+func (m *Matcher) highlight(start, end int, subs ...submatch) string {
+	text := string(m.b[start:end])
+	if !m.color {
+		return text
+	}
+
+	// The capture spans come after the match spans, so that they take precedence
+	var matchSpans, captureSpans []colorSpan
+	for _, sub := range subs {
+		rng := sub.node.Range()
+		matchSpans = append(matchSpans, colorSpan{start: rng.Start.Byte, end: rng.End.Byte, color: colorMatch})
+		names := make([]string, 0, len(sub.values))
+		for name := range sub.values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			val := sub.values[name]
+			if !hasSubstitutionRange(val) {
+				continue
+			}
+			vrng := substitutionRange(val)
+			if vrng.Start.Byte < rng.Start.Byte || vrng.End.Byte > rng.End.Byte {
+				continue
+			}
+			captureSpans = append(captureSpans, colorSpan{start: vrng.Start.Byte, end: vrng.End.Byte, color: captureColors[i%len(captureColors)]})
+		}
+	}
+	spans := append(matchSpans, captureSpans...)
+
+	colorAt := func(offset int) string {
+		var color string
+		for _, span := range spans {
+			if span.start <= offset && offset < span.end {
+				color = span.color
+			}
+		}
+		return color
+	}
+
+	boundaries := []int{start, end}
+	for _, span := range spans {
+		for _, offset := range []int{span.start, span.end} {
+			if start < offset && offset < end {
+				boundaries = append(boundaries, offset)
+			}
+		}
+	}
+	sort.Ints(boundaries)
+
+	var buf strings.Builder
+	for i := 0; i+1 < len(boundaries); i++ {
+		from, to := boundaries[i], boundaries[i+1]
+		if from == to {
+			continue
+		}
+		segment := string(m.b[from:to])
+		if color := colorAt(from); color != "" {
+			segment = m.paint(color, segment)
+		}
+		buf.WriteString(segment)
+	}
+	return buf.String()
+}
@@ -0,0 +1,101 @@
+package hclgrep
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// contextSeparator separates the non-adjacent groups of context lines.
+const contextSeparator = "--"
+
+// lineGroup is a group of consecutive lines to output, with the 1-based line numbers (inclusive).
+type lineGroup struct {
+	start, end int
+	// the lines of the matches inside the group
+	matched map[int]bool
+	// the matches inside the group
+	subs []submatch
+}
+
+// hasContext tells whether the matches are output with their context lines.
+func (m *Matcher) hasContext() bool {
+	return m.before > 0 || m.after > 0
+}
+
+// writeContext writes the lines of the final matches together with the context lines around them. Overlapping or
+// adjacent groups are merged, while the others are separated by contextSeparator.
+func (m *Matcher) writeContext(final []submatch) error {
+	lines := splitLines(m.b)
+	var groups []lineGroup
+	for _, sub := range final {
+		rng := sub.node.Range()
+		start, end := rng.Start.Line, rng.End.Line
+		group := lineGroup{start: start - m.before, end: end + m.after, matched: map[int]bool{}, subs: []submatch{sub}}
+		if group.start < 1 {
+			group.start = 1
+		}
+		if group.end > len(lines) {
+			group.end = len(lines)
+		}
+		for l := start; l <= end; l++ {
+			group.matched[l] = true
+		}
+		if n := len(groups); n != 0 && group.start <= groups[n-1].end+1 {
+			last := &groups[n-1]
+			if group.end > last.end {
+				last.end = group.end
+			}
+			for l := range group.matched {
+				last.matched[l] = true
+			}
+			last.subs = append(last.subs, sub)
+			continue
+		}
+		groups = append(groups, group)
+	}
+
+	// the offsets of the lines
+	offsets := make([]int, len(lines))
+	for i := 1; i < len(lines); i++ {
+		offsets[i] = offsets[i-1] + len(lines[i-1])
+	}
+
+	fileName := relativeFileName(m.fileName)
+	for _, group := range groups {
+		if *m.groupWritten {
+			if _, err := fmt.Fprintln(m.out, m.paint(colorSeparator, contextSeparator)); err != nil {
+				return err
+			}
+		}
+		*m.groupWritten = true
+		for l := group.start; l <= group.end; l++ {
+			// Like grep, the matched lines and the context lines are distinguished by the separator after the prefix
+			sep := "-"
+			if group.matched[l] {
+				sep = ":"
+			}
+			var prefix string
+			switch {
+			case m.lineNumber:
+				prefix = m.paint(colorPrefix, fmt.Sprintf("%s%s%d", fileName, sep, l)) + sep
+			case m.prefix:
+				prefix = m.paint(colorPrefix, fileName) + sep
+			}
+			start, end := offsets[l-1], offsets[l-1]+len(bytes.TrimSuffix(lines[l-1], []byte("\n")))
+			if _, err := fmt.Fprintf(m.out, "%s%s\n", prefix, m.highlight(start, end, group.subs...)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortSubmatches returns the submatches sorted by the start of their nodes.
+func sortSubmatches(subs []submatch) []submatch {
+	sorted := append([]submatch{}, subs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].node.Range().Start.Byte < sorted[j].node.Range().Start.Byte
+	})
+	return sorted
+}
@@ -0,0 +1,214 @@
+package hclgrep
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// diffContext is the number of unchanged lines around the changes in a hunk.
+const diffContext = 3
+
+type diffOpKind int
+
+const (
+	diffEqual diffOpKind = iota
+	diffDelete
+	diffInsert
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line []byte
+}
+
+// splitLines splits the content into lines, each keeps its trailing newline (if any).
+func splitLines(b []byte) [][]byte {
+	var lines [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i == -1 {
+			lines = append(lines, b)
+			break
+		}
+		lines = append(lines, b[:i+1])
+		b = b[i+1:]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script between two lists of lines, using the Myers' algorithm.
+func diffLines(a, b [][]byte) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+	var d int
+outer:
+	for d = 0; d <= max; d++ {
+		vc := make([]int, len(v))
+		copy(vc, v)
+		trace = append(trace, vc)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break outer
+			}
+		}
+	}
+
+	// Backtrack to build the edit script
+	var ops []diffOp
+	x, y := n, m
+	for ; d > 0; d-- {
+		vp := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && vp[offset+k-1] < vp[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vp[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: diffEqual, line: a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{kind: diffInsert, line: b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{kind: diffDelete, line: a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{kind: diffEqual, line: a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// writeUnifiedDiff writes the unified diff between the old and new content of a file. Nothing is written if they
+// are the same.
+func writeUnifiedDiff(w io.Writer, fileName string, old, new []byte) error {
+	ops := diffLines(splitLines(old), splitLines(new))
+
+	var buf bytes.Buffer
+	// i is the index of the current op, oldLine and newLine are the 1-based line numbers of the current op
+	i, oldLine, newLine := 0, 1, 1
+	for i < len(ops) {
+		if ops[i].kind == diffEqual {
+			i, oldLine, newLine = i+1, oldLine+1, newLine+1
+			continue
+		}
+
+		// Find the hunk boundary, which ends when there are more than 2*diffContext consecutive equal lines
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != diffEqual {
+				end++
+				continue
+			}
+			eq := end
+			for eq < len(ops) && ops[eq].kind == diffEqual {
+				eq++
+			}
+			if eq == len(ops) || eq-end > 2*diffContext {
+				end += diffContext
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = eq
+		}
+
+		hunkOldStart, hunkNewStart := oldLine-(i-start), newLine-(i-start)
+		var hunkOldLen, hunkNewLen int
+		var body bytes.Buffer
+		for _, op := range ops[start:end] {
+			line := op.line
+			switch op.kind {
+			case diffEqual:
+				body.WriteByte(' ')
+				hunkOldLen++
+				hunkNewLen++
+			case diffDelete:
+				body.WriteByte('-')
+				hunkOldLen++
+			case diffInsert:
+				body.WriteByte('+')
+				hunkNewLen++
+			}
+			body.Write(line)
+			if !bytes.HasSuffix(line, []byte("\n")) {
+				body.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(hunkOldStart, hunkOldLen), hunkRange(hunkNewStart, hunkNewLen))
+		buf.Write(body.Bytes())
+
+		for _, op := range ops[i:end] {
+			switch op.kind {
+			case diffEqual:
+				oldLine++
+				newLine++
+			case diffDelete:
+				oldLine++
+			case diffInsert:
+				newLine++
+			}
+		}
+		i = end
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+	oldName, newName := fileName, fileName
+	if !filepath.IsAbs(fileName) {
+		oldName, newName = "a/"+fileName, "b/"+fileName
+	}
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		// An empty range starts at the line before it
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
@@ -0,0 +1,25 @@
+package hclgrep
+
+import (
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// headingSeparator separates the blocks in the heading of a match.
+const headingSeparator = " > "
+
+// heading returns the breadcrumb path of the ancestor blocks of the node, from the outermost to the innermost, each
+// of which is its type and labels joined by ".", e.g. "resource.aws_instance.web > network_interface". It returns an
+// empty string for the node that is not inside any block.
+func (m *Matcher) heading(node hclsyntax.Node) string {
+	var blocks []string
+	for parent := m.parentOf(node); parent != nil; parent = m.parentOf(parent) {
+		blk, ok := parent.(*hclsyntax.Block)
+		if !ok {
+			continue
+		}
+		blocks = append([]string{strings.Join(append([]string{blk.Type}, blk.Labels...), ".")}, blocks...)
+	}
+	return strings.Join(blocks, headingSeparator)
+}
@@ -0,0 +1,189 @@
+package hclgrep
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileNames are the names of the files, in increasing precedence, that contain the gitignore style patterns
+// of the files or directories to skip when walking directories.
+var ignoreFileNames = []string{".gitignore", ".ignore", ".hclgrepignore"}
+
+type ignoreRule struct {
+	rx      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreFile represents the rules defined in one ignore file.
+type ignoreFile struct {
+	// the directory where the ignore file resides, the rules are relative to it
+	dir string
+	// the slash separated path of dir relative to the directory of the ignore file, if the ignore file resides in a
+	// parent directory of the walked root
+	prefix string
+	rules  []ignoreRule
+}
+
+// ignoreList is the list of ignore files applied to a directory, which are ordered from the outermost directory to
+// the innermost one.
+type ignoreList []ignoreFile
+
+// loadIgnoreFiles loads the ignore files inside the directory, returning a new list which also includes the rules
+// from the ignore files of the parent directories.
+func (l ignoreList) loadIgnoreFiles(dir string) (ignoreList, error) {
+	out := l
+	for _, name := range ignoreFileNames {
+		rules, err := parseIgnoreFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		if len(out) == len(l) {
+			// Copy on first write, in case the underlying array is shared among sibling directories
+			out = append(ignoreList{}, l...)
+		}
+		out = append(out, ignoreFile{dir: dir, rules: rules})
+	}
+	return out, nil
+}
+
+// ignored tells whether the path is ignored by the rules. The last matched rule wins.
+func (l ignoreList) ignored(fpath string, isDir bool) bool {
+	var ignored bool
+	for _, f := range l {
+		rel, err := filepath.Rel(f.dir, fpath)
+		if err != nil {
+			continue
+		}
+		rel = path.Join(f.prefix, filepath.ToSlash(rel))
+		for _, rule := range f.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.rx.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func parseIgnoreFile(path string) ([]ignoreRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseIgnoreRule parses one line of the ignore file, following the gitignore pattern format.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+
+	line = strings.TrimSuffix(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	// A pattern containing a slash is relative to the directory of the ignore file, otherwise it matches at any level.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var buf strings.Builder
+	buf.WriteString("^")
+	if !anchored {
+		buf.WriteString("(?:.*/)?")
+	}
+	buf.WriteString(globToRegexp(line))
+	buf.WriteString("$")
+	rx, err := regexp.Compile(buf.String())
+	if err != nil {
+		return rule, false
+	}
+	rule.rx = rx
+	return rule, true
+}
+
+// globToRegexp converts a slash separated glob pattern, which supports the "**" as in gitignore, to regexp.
+func globToRegexp(glob string) string {
+	var buf strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				leading := i == 0 || glob[i-1] == '/'
+				switch {
+				case leading && strings.HasPrefix(glob[i:], "**/"):
+					// "**/" matches zero or more directories
+					buf.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				case leading && i+2 == len(glob):
+					// trailing "/**" matches everything inside
+					buf.WriteString(".*")
+					i++
+					continue
+				}
+			}
+			buf.WriteString("[^/]*")
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				buf.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				buf.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String()
+}
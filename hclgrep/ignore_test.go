@@ -0,0 +1,57 @@
+package hclgrep
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIgnoreRule(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.tfstate", "terraform.tfstate", false, true},
+		{"*.tfstate", "a/b/terraform.tfstate", false, true},
+		{"*.tfstate", "terraform.tfstate.backup", false, false},
+		{".terraform", "a/.terraform", true, true},
+		{".terraform/", "a/.terraform", true, true},
+		{".terraform/", "a/.terraform", false, false},
+		{"/vendor", "vendor", true, true},
+		{"/vendor", "a/vendor", true, false},
+		{"a/b", "a/b", false, true},
+		{"a/b", "c/a/b", false, false},
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"a/**", "a/b/c", false, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a?c", "abc", false, true},
+		{"a?c", "a/c", false, false},
+		{"[ab].tf", "a.tf", false, true},
+		{"[!ab].tf", "a.tf", false, false},
+		{`\#foo`, "#foo", false, true},
+	}
+
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			rule, ok := parseIgnoreRule(tc.pattern)
+			if !ok {
+				t.Fatalf("%q: invalid rule", tc.pattern)
+			}
+			l := ignoreList{{dir: ".", rules: []ignoreRule{rule}}}
+			if got := l.ignored(tc.path, tc.isDir); got != tc.want {
+				t.Fatalf("%q against %q: wanted %t, got %t", tc.pattern, tc.path, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestIgnoreRuleSkipped(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "!", "/"} {
+		if _, ok := parseIgnoreRule(line); ok {
+			t.Fatalf("%q: expected to be skipped", line)
+		}
+	}
+}
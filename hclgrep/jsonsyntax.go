@@ -0,0 +1,235 @@
+package hclgrep
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+)
+
+// isJSONSyntax tells whether the file is written in the JSON syntax of HCL (e.g. "main.tf.json").
+func isJSONSyntax(fileName string) bool {
+	return strings.HasSuffix(fileName, ".json")
+}
+
+// parseJSONSyntax parses the source in the JSON syntax of HCL, mapping it onto the native syntax tree so that it can
+// be matched against the native syntax patterns, with the positions referring to the JSON source:
+//
+//   - A property whose value is an object becomes a block without labels, whose type is the property name. Nested
+//     objects therefore represent the labels of a block as nested blocks (e.g. {"resource": {"type": {"name": {}}}}).
+//   - A property whose value is an array of objects becomes one block for each of the objects.
+//   - Any other property becomes an attribute. Strings are parsed as templates, where a string containing only one
+//     interpolation (e.g. "${var.foo}") is the interpolated expression itself.
+//
+// Since an object valued property can also be an attribute of object type, the returned map records the object
+// expression of each of such blocks. This only applies to the leaf bodies, which have other attributes, as the
+// object valued properties of a body without attributes are regarded as labels (e.g. {"variable": {"ami": {}}}).
+func parseJSONSyntax(src []byte, fileName string) (*hclsyntax.Body, map[*hclsyntax.Block]*hclsyntax.ObjectConsExpr, hcl.Diagnostics) {
+	root, diags := hcljson.ParseExpression(src, fileName)
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+	if !isJSONObject(root) {
+		return nil, nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Root value must be object",
+			Detail:   "The root value in a JSON-based configuration must be a JSON object.",
+			Subject:  root.StartRange().Ptr(),
+		}}
+	}
+	c := jsonConverter{src: src, fileName: fileName, objects: map[*hclsyntax.Block]*hclsyntax.ObjectConsExpr{}}
+	return c.body(root), c.objects, nil
+}
+
+// jsonProps returns the properties of the JSON object in the source order, or false if the value is not an object.
+func jsonProps(expr hcl.Expression) ([]hcl.KeyValuePair, bool) {
+	obj, ok := expr.(interface{ ExprMap() []hcl.KeyValuePair })
+	if !ok {
+		return nil, false
+	}
+	props := obj.ExprMap()
+	return props, props != nil
+}
+
+// jsonElems returns the elements of the JSON array, or false if the value is not an array.
+func jsonElems(expr hcl.Expression) ([]hcl.Expression, bool) {
+	arr, ok := expr.(interface{ ExprList() []hcl.Expression })
+	if !ok {
+		return nil, false
+	}
+	elems := arr.ExprList()
+	return elems, elems != nil
+}
+
+// jsonPropName returns the name of the JSON property, which is always a string.
+func jsonPropName(prop hcl.KeyValuePair) string {
+	name, _ := prop.Key.Value(nil)
+	return name.AsString()
+}
+
+func isJSONObject(expr hcl.Expression) bool {
+	_, ok := jsonProps(expr)
+	return ok
+}
+
+func isJSONObjectArray(expr hcl.Expression) bool {
+	elems, ok := jsonElems(expr)
+	if !ok || len(elems) == 0 {
+		return false
+	}
+	for _, elem := range elems {
+		if !isJSONObject(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonConverter converts the JSON values, parsed by hcl/json, to the native syntax nodes.
+type jsonConverter struct {
+	src      []byte
+	fileName string
+	objects  map[*hclsyntax.Block]*hclsyntax.ObjectConsExpr
+}
+
+func (c *jsonConverter) body(obj hcl.Expression) *hclsyntax.Body {
+	rng := obj.Range()
+	body := &hclsyntax.Body{
+		Attributes: hclsyntax.Attributes{},
+		SrcRange:   rng,
+		EndRange:   hcl.Range{Filename: c.fileName, Start: rng.End, End: rng.End},
+	}
+	var props []hcl.KeyValuePair
+	leaf := false
+	all, _ := jsonProps(obj)
+	for _, prop := range all {
+		// The "//" properties are comments
+		if jsonPropName(prop) == "//" {
+			continue
+		}
+		props = append(props, prop)
+		if !isJSONObject(prop.Value) && !isJSONObjectArray(prop.Value) {
+			leaf = true
+		}
+	}
+	for _, prop := range props {
+		name, nameRange := jsonPropName(prop), prop.Key.Range()
+		switch {
+		case isJSONObject(prop.Value):
+			blk := c.block(name, nameRange, prop.Value)
+			if leaf {
+				c.objects[blk] = c.expr(prop.Value).(*hclsyntax.ObjectConsExpr)
+			}
+			body.Blocks = append(body.Blocks, blk)
+		case isJSONObjectArray(prop.Value):
+			// Each block starts from its opening brace, so that they are distinguishable by position
+			elems, _ := jsonElems(prop.Value)
+			for _, elem := range elems {
+				body.Blocks = append(body.Blocks, c.block(name, c.openRange(elem), elem))
+			}
+		default:
+			body.Attributes[name] = &hclsyntax.Attribute{
+				Name:      name,
+				Expr:      c.expr(prop.Value),
+				SrcRange:  hcl.RangeBetween(nameRange, prop.Value.Range()),
+				NameRange: nameRange,
+			}
+		}
+	}
+	return body
+}
+
+func (c *jsonConverter) block(typ string, typeRange hcl.Range, obj hcl.Expression) *hclsyntax.Block {
+	return &hclsyntax.Block{
+		Type:            typ,
+		Body:            c.body(obj),
+		TypeRange:       typeRange,
+		OpenBraceRange:  c.openRange(obj),
+		CloseBraceRange: c.closeRange(obj),
+	}
+}
+
+// openRange returns the range of the opening brace (or bracket) of the JSON object (or array).
+func (c *jsonConverter) openRange(v hcl.Expression) hcl.Range {
+	start := v.Range().Start
+	end := hcl.Pos{Line: start.Line, Column: start.Column + 1, Byte: start.Byte + 1}
+	return hcl.Range{Filename: c.fileName, Start: start, End: end}
+}
+
+// closeRange returns the range of the closing brace (or bracket) of the JSON object (or array).
+func (c *jsonConverter) closeRange(v hcl.Expression) hcl.Range {
+	end := v.Range().End
+	start := hcl.Pos{Line: end.Line, Column: end.Column - 1, Byte: end.Byte - 1}
+	return hcl.Range{Filename: c.fileName, Start: start, End: end}
+}
+
+func (c *jsonConverter) expr(v hcl.Expression) hclsyntax.Expression {
+	if props, ok := jsonProps(v); ok {
+		expr := &hclsyntax.ObjectConsExpr{SrcRange: v.Range(), OpenRange: c.openRange(v)}
+		for _, prop := range props {
+			name, nameRange := jsonPropName(prop), prop.Key.Range()
+			var key hclsyntax.Expression
+			if hclsyntax.ValidIdentifier(name) {
+				key = &hclsyntax.ScopeTraversalExpr{
+					Traversal: hcl.Traversal{hcl.TraverseRoot{Name: name, SrcRange: nameRange}},
+					SrcRange:  nameRange,
+				}
+			} else {
+				key = c.literalString(name, nameRange)
+			}
+			expr.Items = append(expr.Items, hclsyntax.ObjectConsItem{
+				KeyExpr:   &hclsyntax.ObjectConsKeyExpr{Wrapped: key},
+				ValueExpr: c.expr(prop.Value),
+			})
+		}
+		return expr
+	}
+	if elems, ok := jsonElems(v); ok {
+		expr := &hclsyntax.TupleConsExpr{SrcRange: v.Range(), OpenRange: c.openRange(v)}
+		for _, elem := range elems {
+			expr.Exprs = append(expr.Exprs, c.expr(elem))
+		}
+		return expr
+	}
+	// Without an evaluation context, a string is evaluated as a literal, as the other primitive values are
+	val, _ := v.Value(nil)
+	if val.Type() == cty.String && val.IsKnown() && !val.IsNull() {
+		return c.template(v, val.AsString())
+	}
+	return &hclsyntax.LiteralValueExpr{Val: val, SrcRange: v.Range()}
+}
+
+// template parses the JSON string, whose decoded value is str, as a template.
+func (c *jsonConverter) template(v hcl.Expression, str string) hclsyntax.Expression {
+	// The raw source, excluding the quotes, is parsed if there is no escape sequence, so that the positions of the
+	// template parts are accurate. Otherwise, the decoded string is parsed.
+	rng := v.Range()
+	src := c.src[rng.Start.Byte+1 : rng.End.Byte-1]
+	if bytes.ContainsRune(src, '\\') {
+		src = []byte(str)
+	}
+	start := hcl.Pos{Line: rng.Start.Line, Column: rng.Start.Column + 1, Byte: rng.Start.Byte + 1}
+	expr, diags := hclsyntax.ParseTemplate(src, c.fileName, start)
+	if diags.HasErrors() {
+		return c.literalString(str, rng)
+	}
+	switch expr := expr.(type) {
+	case *hclsyntax.TemplateWrapExpr:
+		return expr.Wrapped
+	case *hclsyntax.TemplateExpr:
+		// Include the quotes, as the native syntax does
+		expr.SrcRange = rng
+	}
+	return expr
+}
+
+func (c *jsonConverter) literalString(s string, rng hcl.Range) *hclsyntax.TemplateExpr {
+	return &hclsyntax.TemplateExpr{
+		Parts:    []hclsyntax.Expression{&hclsyntax.LiteralValueExpr{Val: cty.StringVal(s), SrcRange: rng}},
+		SrcRange: rng,
+	}
+}
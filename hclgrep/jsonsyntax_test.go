@@ -0,0 +1,94 @@
+package hclgrep
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestJSONSyntax(t *testing.T) {
+	src := `{
+  "resource": {
+    "aws_instance": {
+      "web": {
+        "ami": "${var.ami}",
+        "tags": {"Name": "web"},
+        "ebs": [{"size": 10}, {"size": 20}]
+      },
+      "db": {
+        "ami": "ami-123",
+        "count": 2
+      }
+    }
+  },
+  "variable": {
+    "ami": {"default": "ami-0"}
+  }
+}`
+	tests := []struct {
+		args []string
+		want interface{}
+	}{
+		// attributes, with the single interpolation unwrapped
+		{[]string{"-x", "ami = var.$v", "-w", "v"}, "ami\n"},
+		{[]string{"-x", `ami = "ami-123"`}, "\"ami\": \"ami-123\"\n"},
+		{[]string{"-x", "count = $c", "-w", "c"}, "2\n"},
+		// object valued properties are matched as both attributes and blocks
+		{[]string{"-x", "tags = {Name = $n}", "-w", "n"}, "\"web\"\n"},
+		{[]string{"-x", "tags {Name = $n}", "-w", "n"}, "\"web\"\n"},
+		// object valued properties without sibling attributes are labels, which are not attributes
+		{[]string{"-x", "ami = $a", "-w", "a"}, "var.ami\n\"ami-123\"\n"},
+		{[]string{"-x", "variable ami {default = $d}", "-w", "d"}, "\"ami-0\"\n"},
+		// arrays of objects are blocks
+		{[]string{"-x", "ebs { size = $s }", "-w", "s"}, "10\n20\n"},
+		// labels are nested objects
+		{[]string{"-x", "resource aws_instance $n {@*_}", "-w", "n"}, "web\ndb\n"},
+		{[]string{"-x", "resource aws_instance db {@*_}"}, "\"db\": {\n        \"ami\": \"ami-123\",\n        \"count\": 2\n      }\n"},
+		{[]string{"-x", "resource aws_instance $n {\n@*_\ncount = $_\n}", "-w", "n"}, "db\n"},
+		{[]string{"-x", "resource $t $n {\n@*_\n}", "-x", "ami = $_", "-w", "t"}, "aws_instance\naws_instance\n"},
+		// labels inside a body
+		{[]string{"-x", "@*_\nresource aws_instance $n {\n@*_\ncount = $_\n}\n@*_", "-w", "n"}, "db\n"},
+		// positions refer to the JSON source
+		{[]string{"-json", "-x", "count = $c"}, `{"filename":"main.tf.json","start":{"line":11,"column":9,"byte":218},"end":{"line":11,"column":19,"byte":228},"text":"\"count\": 2","type":"Attribute","captures":{"c":{"kind":"node","text":"2","type":"LiteralValueExpr","range":{"start":{"line":11,"column":18,"byte":227},"end":{"line":11,"column":19,"byte":228}}}}}
+`},
+		// rewriting is not supported
+		{[]string{"-x", "count = $c", "-s", "count = 3"}, wantErr("rewriting files in the JSON syntax is not supported")},
+	}
+
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			opts, _, err := ParseArgs(tc.args)
+			if err != nil {
+				t.Fatalf("%v: unexpected error: %v", tc.args, err)
+			}
+			buf := bytes.NewBufferString("")
+			m := NewMatcher(append(opts, OptionOutput(buf))...)
+			err = m.File("main.tf.json", bytes.NewBufferString(src))
+			if want, ok := tc.want.(wantErr); ok {
+				if err == nil || err.Error() != string(want) {
+					t.Fatalf("%v: wanted error %q, got %v", tc.args, want, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v: unexpected error: %v", tc.args, err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Fatalf("%v: wanted:\n%s\ngot:\n%s", tc.args, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestJSONSyntaxInvalid(t *testing.T) {
+	opts, _, err := ParseArgs([]string{"-x", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMatcher(opts...)
+	for _, src := range []string{`["a"]`, `{"a": `} {
+		if err := m.File("main.tf.json", bytes.NewBufferString(src)); err == nil {
+			t.Errorf("%s: wanted error, got none", src)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package hclgrep
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/zclconf/go-cty/cty"
 
@@ -17,6 +21,9 @@ import (
 type Matcher struct {
 	out io.Writer
 
+	// the output of the diagnostics of the files that can't be parsed
+	errOut io.Writer
+
 	cmds []Cmd
 
 	parents map[hclsyntax.Node]hclsyntax.Node
@@ -25,9 +32,94 @@ type Matcher struct {
 	// whether prefix the matches with filenname and byte offset
 	prefix bool
 
+	// whether prefix the matches with filename, line and column on the same line
+	lineNumber bool
+
+	// whether output each line of the matches prefixed with filename, line and column
+	vimgrep bool
+
+	// whether color the output
+	color bool
+
+	// the template to render each match with, instead of the default format
+	format *template.Template
+
+	// whether output the breadcrumb path of the ancestor blocks above each match
+	withHeading bool
+
+	// the number of the context lines to output before and after each match
+	before, after int
+
+	// whether any group of context lines is written, which is shared among the files so that the groups of
+	// different files are also separated
+	groupWritten *bool
+
+	// whether output the matches (and the written wildcards) as JSON objects
+	json bool
+
+	// the name of the file being matched
+	fileName string
+
+	// the extensions of the files to search when walking directories, defaults to defaultExtensions
+	extensions []string
+
+	// the glob patterns that the files found when walking directories must match any of
+	includes []string
+
+	// the glob patterns that the files or directories found when walking directories must not match
+	excludes []string
+
+	// whether search the hidden files and directories when walking directories
+	hidden bool
+
+	// whether not respect the ignore files (e.g. .gitignore) when walking directories
+	noIgnore bool
+
+	// the number of files to process concurrently
+	jobs int
+
+	// whether modify the files in place for the rewriting commands
+	rewriteWrite bool
+
+	// whether output the diff for the rewriting commands
+	rewriteDiff bool
+
+	// if set, the in place modification of the rewritten file is deferred to it instead of being done immediately,
+	// so that the files being processed concurrently are modified in order
+	deferredWrite *func() error
+
+	// whether output the number of matches of each file, instead of the matches
+	count bool
+
+	// whether output the names of the files with (or without) any match, instead of the matches
+	filesWithMatches    bool
+	filesWithoutMatches bool
+
+	// whether stop at the first file that can't be parsed, instead of reporting it and continuing
+	strict bool
+
+	// whether match the body recovered from a file with syntax errors
+	lenient bool
+
+	// the ranges of the syntax errors of the file being matched, in lenient mode
+	errRanges []hcl.Range
+
+	// whether match the attributes/blocks of the bodies in any order
+	unordered bool
+
 	// node values recorded by name, excluding "_" (used only by the
 	// actual matching phase)
 	values map[string]substitution
+
+	// the object expressions of the object valued properties, which are parsed as blocks, in case the file being
+	// matched is in the JSON syntax. It is nil for the native syntax.
+	jsonObjects map[*hclsyntax.Block]*hclsyntax.ObjectConsExpr
+
+	// the nesting level of the bodies being matched
+	bodyDepth int
+
+	// the number of the lines printed by the "-w" commands for the file being matched
+	written int
 }
 
 func NewMatcher(opts ...Option) Matcher {
@@ -38,70 +130,341 @@ func NewMatcher(opts ...Option) Matcher {
 	if m.out == nil {
 		m.out = os.Stdout
 	}
+	if m.errOut == nil {
+		m.errOut = os.Stderr
+	}
+	m.groupWritten = new(bool)
 	return m
 }
 
+// Result is the summary of matching multiple files.
+type Result struct {
+	// the number of the final matches
+	Matches int
+	// the number of the files where anything is selected, which is either a final match, a value printed by "-w",
+	// or the file name printed by "-L"
+	SelectedFiles int
+	// the number of the files scanned, including the failed ones
+	Files int
+	// the number of the files failed to be processed
+	FailedFiles int
+	// the number of the files that have syntax errors, but are still searched in lenient mode
+	InvalidFiles int
+}
+
+func (r *Result) add(fr fileResult, err error) {
+	r.Files++
+	r.Matches += fr.matches
+	if fr.selected {
+		r.SelectedFiles++
+	}
+	if fr.invalid {
+		r.InvalidFiles++
+	}
+	if err != nil {
+		r.FailedFiles++
+	}
+}
+
+// fileResult is the result of matching one file.
+type fileResult struct {
+	// the number of the final matches
+	matches int
+	// whether anything is selected, as described in Result
+	selected bool
+	// whether the file has syntax errors, which is searched in lenient mode
+	invalid bool
+}
+
 // Files matches multiple Files, output the final matches to matcher's out. In case the length of the files is 0, it matches the content from the stdin.
-func (m *Matcher) Files(files []string) error {
+// The directories are walked recursively for files with the HCL extensions.
+func (m *Matcher) Files(files []string) (Result, error) {
+	var res Result
 	if len(files) == 0 {
-		if err := m.File("stdin", os.Stdin); err != nil {
-			return err
+		if m.rewriteWrite {
+			return res, fmt.Errorf("can't modify stdin in place")
 		}
+		fr, err := m.file("stdin", os.Stdin)
+		res.add(fr, err)
+		return res, m.skipError(err)
+	}
+
+	files, err := m.expandPaths(files)
+	if err != nil {
+		return res, err
+	}
+	// The rewritten files printed back to back can't be told apart
+	if isRewriteCmd(m.cmds[len(m.cmds)-1].name) && !m.rewriteWrite && !m.rewriteDiff && len(files) > 1 {
+		return res, fmt.Errorf("can't print the rewritten content of multiple files, use `-write` or `-diff`")
+	}
+	if m.jobs > 1 {
+		res, err = m.parallelFiles(files)
+	} else {
+		for _, file := range files {
+			var fr fileResult
+			fr, err = m.openFile(file)
+			res.add(fr, err)
+			if err = m.skipError(err); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+	if m.count && len(files) > 1 {
+		_, err = fmt.Fprintf(m.out, "total:%d\n", res.Matches)
+	}
+	return res, err
+}
+
+type fileOutput struct {
+	buf          bytes.Buffer
+	errBuf       bytes.Buffer
+	result       fileResult
+	err          error
+	groupWritten bool
+	write        func() error
+}
+
+// parallelFiles processes the files with a pool of workers. The output of each file is buffered, and is written
+// to the matcher's out in the order of the files. The files are also modified in order, so that none is modified
+// after a failed one, the same as processing the files sequentially.
+func (m *Matcher) parallelFiles(files []string) (Result, error) {
+	indexes := make(chan int, len(files))
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+
+	outputs := make([]chan *fileOutput, len(files))
+	for i := range outputs {
+		outputs[i] = make(chan *fileOutput, 1)
+	}
+
+	// stop is closed on return, so that workers don't process the remaining files in case of error. The running
+	// workers are waited for, so that none outlives the call.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	for w := 0; w < m.jobs && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				output := &fileOutput{}
+				fm := *m
+				fm.out = &output.buf
+				fm.errOut = &output.errBuf
+				fm.groupWritten = &output.groupWritten
+				fm.deferredWrite = &output.write
+				output.result, output.err = fm.openFile(files[i])
+				outputs[i] <- output
+			}
+		}()
 	}
 
-	for _, file := range files {
-		in, err := os.Open(file)
-		if err != nil {
-			return fmt.Errorf("openning %s: %w", file, err)
+	var res Result
+	for _, ch := range outputs {
+		output := <-ch
+		if output.groupWritten {
+			if *m.groupWritten {
+				if _, err := fmt.Fprintln(m.out, m.paint(colorSeparator, contextSeparator)); err != nil {
+					return res, err
+				}
+			}
+			*m.groupWritten = true
 		}
-		err = m.File(file, in)
-		in.Close()
-		if err != nil {
-			return fmt.Errorf("processing %s: %w", file, err)
+		if _, err := output.buf.WriteTo(m.out); err != nil {
+			return res, err
+		}
+		if _, err := output.errBuf.WriteTo(m.errOut); err != nil {
+			return res, err
+		}
+		err := output.err
+		if err == nil && output.write != nil {
+			err = output.write()
+		}
+		res.add(output.result, err)
+		if err := m.skipError(err); err != nil {
+			return res, err
 		}
 	}
-	return nil
+	return res, nil
+}
+
+// parseError is the error of a file that can't be parsed.
+type parseError struct {
+	fileName string
+	src      []byte
+	diags    hcl.Diagnostics
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("cannot parse source: %s", e.diags.Error())
+}
+
+// skipError reports the diagnostics of the file that can't be parsed and skips the error, unless in strict mode.
+// Other errors are returned as is.
+func (m *Matcher) skipError(err error) error {
+	var perr *parseError
+	if m.strict || !errors.As(err, &perr) {
+		return err
+	}
+	return m.writeDiagnostics(perr.fileName, perr.src, perr.diags)
+}
+
+// writeDiagnostics writes the diagnostics of a file to the error output.
+func (m *Matcher) writeDiagnostics(fileName string, src []byte, diags hcl.Diagnostics) error {
+	files := map[string]*hcl.File{fileName: {Bytes: src}}
+	return hcl.NewDiagnosticTextWriter(m.errOut, files, 0, false).WriteDiagnostics(diags)
+}
+
+// invalidMarker marks the matches overlapping any syntax error in lenient mode.
+const invalidMarker = "(invalid)"
+
+// invalid tells whether the node overlaps any syntax error of the file being matched. As an error is usually reported
+// at the token following an incomplete node, the node is considered to extend to the end of its last line.
+func (m *Matcher) invalid(node hclsyntax.Node) bool {
+	rng := node.Range()
+	end := rng.End.Byte
+	if i := bytes.IndexByte(m.b[end:], '\n'); i != -1 {
+		end += i
+	} else {
+		end = len(m.b)
+	}
+	for _, errRange := range m.errRanges {
+		if errRange.Start.Byte <= end && rng.Start.Byte < errRange.End.Byte {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) openFile(file string) (fileResult, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return fileResult{}, fmt.Errorf("openning %s: %w", file, err)
+	}
+	defer in.Close()
+	fr, err := m.file(file, in)
+	if err != nil {
+		return fr, fmt.Errorf("processing %s: %w", file, err)
+	}
+	return fr, nil
 }
 
 // File matches one File, output the final matches to matcher's out.
 func (m *Matcher) File(fileName string, in io.Reader) error {
+	_, err := m.file(fileName, in)
+	return err
+}
+
+// file matches one file, returning the number of the final matches and whether anything is selected.
+func (m *Matcher) file(fileName string, in io.Reader) (fileResult, error) {
+	// Each file gets its own matching state, so that the matcher can be used concurrently.
+	fm := *m
+	return fm.matchFile(fileName, in)
+}
+
+func (m *Matcher) matchFile(fileName string, in io.Reader) (fileResult, error) {
+	m.fileName = fileName
 	m.parents = make(map[hclsyntax.Node]hclsyntax.Node)
 	var err error
 	m.b, err = io.ReadAll(in)
 	if err != nil {
-		return err
+		return fileResult{}, err
 	}
-	f, diags := hclsyntax.ParseConfig(m.b, fileName, hcl.InitialPos)
+	lastCmd := m.cmds[len(m.cmds)-1]
+	body, diags := m.parse(fileName)
 	if diags.HasErrors() {
-		return fmt.Errorf("cannot parse source: %s", diags.Error())
+		// Files with syntax errors are never rewritten
+		if body == nil || isRewriteCmd(lastCmd.name) {
+			return fileResult{}, &parseError{fileName: fileName, src: m.b, diags: diags}
+		}
+		if err := m.writeDiagnostics(fileName, m.b, diags); err != nil {
+			return fileResult{}, err
+		}
+		for _, diag := range diags {
+			if diag.Severity == hcl.DiagError && diag.Subject != nil {
+				m.errRanges = append(m.errRanges, *diag.Subject)
+			}
+		}
 	}
-	matches := m.matches(f.Body.(*hclsyntax.Body))
-	wd, _ := os.Getwd()
 
-	if m.cmds[len(m.cmds)-1].name == CmdNameWrite {
-		return nil
+	if isRewriteCmd(lastCmd.name) && m.jsonObjects != nil {
+		return fileResult{}, fmt.Errorf("rewriting files in the JSON syntax is not supported")
+	}
+	m.written = 0
+	final := m.finalSubmatches(body)
+	fr := fileResult{matches: len(final), selected: len(final) != 0 || m.written != 0, invalid: diags.HasErrors()}
+	switch {
+	case m.count:
+		_, err := fmt.Fprintf(m.out, "%s:%d\n", relativeFileName(fileName), len(final))
+		return fr, err
+	case m.filesWithoutMatches:
+		// As grep does, the file printed by -L is the one selected
+		fr.selected = len(final) == 0
+		if !fr.selected {
+			return fr, nil
+		}
+		_, err := fmt.Fprintln(m.out, relativeFileName(fileName))
+		return fr, err
+	case m.filesWithMatches:
+		if len(final) == 0 {
+			return fr, nil
+		}
+		_, err := fmt.Fprintln(m.out, relativeFileName(fileName))
+		return fr, err
+	}
+	if lastCmd.name == CmdNameWrite {
+		// The final matches whose values are all missing are not printed
+		fr.selected = m.written != 0
+		return fr, nil
+	}
+	if isRewriteCmd(lastCmd.name) {
+		return fr, m.rewrite(lastCmd, final)
+	}
+	if m.hasContext() {
+		return fr, m.writeContext(final)
 	}
 
-	for _, n := range matches {
-		rng := n.Range()
-		output := string(rng.SliceBytes(m.b))
-		if m.prefix {
-			if strings.HasPrefix(rng.Filename, wd) {
-				rng.Filename = rng.Filename[len(wd)+1:]
-			}
-			output = fmt.Sprintf("%s:\n%s", rng, output)
+	for _, sub := range final {
+		if err := m.writeMatch(sub); err != nil {
+			return fr, err
 		}
+	}
+	return fr, nil
+}
 
-		fmt.Fprintf(m.out, "%s\n", output)
+// parse parses the file content, either in the native syntax or in the JSON syntax, based on the file name.
+func (m *Matcher) parse(fileName string) (*hclsyntax.Body, hcl.Diagnostics) {
+	if isJSONSyntax(fileName) {
+		body, objects, diags := parseJSONSyntax(m.b, fileName)
+		m.jsonObjects = objects
+		return body, diags
 	}
-	return nil
+	f, diags := hclsyntax.ParseConfig(m.b, fileName, hcl.InitialPos)
+	if diags.HasErrors() && !m.lenient {
+		return nil, diags
+	}
+	// The body is recovered on a best-effort basis in case of errors
+	body, _ := f.Body.(*hclsyntax.Body)
+	return body, diags
 }
 
 // matches matches one node.
 func (m *Matcher) matches(node hclsyntax.Node) []hclsyntax.Node {
-	m.fillParents(node)
-	initial := []submatch{{node: node, values: map[string]substitution{}}}
-	final := m.submatches(m.cmds, initial)
+	final := m.finalSubmatches(node)
 	matches := make([]hclsyntax.Node, len(final))
 	for i := range matches {
 		matches[i] = final[i].node
@@ -109,6 +472,14 @@ func (m *Matcher) matches(node hclsyntax.Node) []hclsyntax.Node {
 	return matches
 }
 
+// finalSubmatches runs all the commands against one node, returning the submatches of the last command in the
+// order of their positions.
+func (m *Matcher) finalSubmatches(node hclsyntax.Node) []submatch {
+	m.fillParents(node)
+	initial := []submatch{{node: node, values: map[string]substitution{}}}
+	return sortSubmatches(m.submatches(m.cmds, initial))
+}
+
 type parentsWalker struct {
 	stack   []hclsyntax.Node
 	parents map[hclsyntax.Node]hclsyntax.Node
@@ -172,6 +543,9 @@ func (m *Matcher) submatches(cmds []Cmd, subs []submatch) []submatch {
 		fn = m.cmdRx
 	case CmdNameWrite:
 		fn = m.cmdWrite
+	case CmdNameSubst, CmdNameDelete, CmdNameInsertBody, CmdNameAppend:
+		// The rewriting happens after all the commands are run
+		fn = func(_ Cmd, subs []submatch) []submatch { return subs }
 	default:
 		panic(fmt.Sprintf("unknown command: %q", cmd.name))
 	}
@@ -192,7 +566,8 @@ func (m *Matcher) cmdMatch(cmd Cmd, subs []submatch) []submatch {
 			return nil
 		})
 	}
-	return matches
+	// The attributes of a body are visited in no particular order
+	return sortSubmatches(matches)
 }
 
 func (m *Matcher) cmdFilter(wantMatch bool) func(Cmd, []submatch) []submatch {
@@ -246,94 +621,188 @@ func (m *Matcher) cmdRx(cmd Cmd, subs []submatch) []submatch {
 		if !ok {
 			continue
 		}
-		var valLit string
+		if m.rxMatches(rx, val) {
+			newsubs = append(newsubs, sub)
+		}
+	}
+	return newsubs
+}
+
+// rxMatches checks whether the recorded wildcard value matches the regexp of the "-rx" command. A list recorded by
+// an any wildcard matches if any of its elements matches.
+func (m *Matcher) rxMatches(rx CmdValueRx, val substitution) bool {
+	var valLit string
+	ok := true
+	switch {
+	case val.List != nil:
+		for _, elem := range val.List {
+			if m.rxMatches(rx, elem) {
+				return true
+			}
+		}
+		return false
+	case rx.selector != "":
+		// The key or the value of an object item or an attribute
+		var key, value hclsyntax.Node
 		switch {
-		case val.String != nil:
-			valLit = *val.String
+		case val.ObjectConsItem != nil:
+			key, value = val.ObjectConsItem.KeyExpr, val.ObjectConsItem.ValueExpr
 		case val.Node != nil:
-			var ok bool
-			// check whether the node is a variable
-			valLit, ok = variableExpr(val.Node)
-			if !ok {
-				switch node := val.Node.(type) {
-				case *hclsyntax.TemplateExpr:
-					if len(node.Parts) != 1 {
-						continue
-					}
-					tmpl := node.Parts[0]
-					lve, ok := tmpl.(*hclsyntax.LiteralValueExpr)
-					if !ok {
-						continue
-					}
-					value, _ := lve.Value(nil)
-					valLit = value.AsString()
-				case *hclsyntax.LiteralValueExpr:
-					value, _ := node.Value(nil)
-					switch value.Type() {
-					case cty.String:
-						valLit = value.AsString()
-					case cty.Bool:
-						valLit = "true"
-						if value.False() {
-							valLit = "false"
-						}
-					case cty.Number:
-						// TODO: handle float?
-						valLit = value.AsBigFloat().String()
-					}
-				}
+			attr, isAttr := val.Node.(*hclsyntax.Attribute)
+			if !isAttr {
+				return false
 			}
-		case val.ObjectConsItem != nil:
-		case val.Traverser != nil:
-			switch trav := (*val.Traverser).(type) {
-			case hcl.TraverseRoot:
-				valLit = trav.Name
-			case hcl.TraverseAttr:
-				valLit = trav.Name
-			default:
-				continue
+			value = attr.Expr
+			if rx.selector == rxSelectorKey {
+				valLit = attr.Name
+			}
+		default:
+			return false
+		}
+		switch {
+		case rx.selector == rxSelectorValue:
+			valLit, ok = nodeLiteral(value)
+		case key != nil:
+			if key, isKey := key.(*hclsyntax.ObjectConsKeyExpr); isKey && !key.ForceNonLiteral {
+				if name := hcl.ExprAsKeyword(key.Wrapped); name != "" {
+					valLit = name
+					break
+				}
 			}
+			valLit, ok = nodeLiteral(key)
+		}
+		if !ok {
+			return false
+		}
+	case val.String != nil:
+		valLit = *val.String
+	case val.Node != nil:
+		if valLit, ok = nodeLiteral(val.Node); !ok {
+			return false
+		}
+	case val.ObjectConsItem != nil:
+		valLit = m.objectConsItemText(*val.ObjectConsItem)
+	case val.Traverser != nil:
+		switch trav := (*val.Traverser).(type) {
+		case hcl.TraverseRoot:
+			valLit = trav.Name
+		case hcl.TraverseAttr:
+			valLit = trav.Name
 		default:
-			panic("never reach here")
+			return false
 		}
+	default:
+		panic("never reach here")
+	}
 
-		if rx.rx.MatchString(valLit) {
-			newsubs = append(newsubs, sub)
+	return rx.rx.MatchString(valLit)
+}
+
+// nodeLiteral returns the literal of the node to be filtered by the regexp, which is the name of a variable, or the
+// value of a literal or a template without interpolation. It returns false if the node is a template with
+// interpolations.
+func nodeLiteral(node hclsyntax.Node) (string, bool) {
+	if key, ok := node.(*hclsyntax.ObjectConsKeyExpr); ok {
+		node = key.Wrapped
+	}
+	// check whether the node is a variable
+	if name, ok := variableExpr(node); ok {
+		return name, true
+	}
+	switch node := node.(type) {
+	case *hclsyntax.TemplateExpr:
+		if len(node.Parts) != 1 {
+			return "", false
+		}
+		lve, ok := node.Parts[0].(*hclsyntax.LiteralValueExpr)
+		if !ok {
+			return "", false
+		}
+		value, _ := lve.Value(nil)
+		return value.AsString(), true
+	case *hclsyntax.LiteralValueExpr:
+		value, _ := node.Value(nil)
+		switch value.Type() {
+		case cty.String:
+			return value.AsString(), true
+		case cty.Bool:
+			if value.False() {
+				return "false", true
+			}
+			return "true", true
+		case cty.Number:
+			// TODO: handle float?
+			return value.AsBigFloat().String(), true
 		}
 	}
-	return newsubs
+	return "", true
+}
+
+// objectConsItemText returns the source text of the object item in the form of "key = value".
+func (m *Matcher) objectConsItemText(item hclsyntax.ObjectConsItem) string {
+	return string(item.KeyExpr.Range().SliceBytes(m.b)) + " = " + string(item.ValueExpr.Range().SliceBytes(m.b))
 }
 
 func (m *Matcher) cmdWrite(cmd Cmd, subs []submatch) []submatch {
+	names := cmd.value.Value().(CmdValueNames)
 	for _, sub := range subs {
-		name := string(cmd.value.Value().(CmdValueString))
-		val, ok := sub.values[name]
-		if !ok {
+		if m.json {
+			for _, name := range names {
+				if val, ok := sub.values[name]; ok {
+					// The error is ignored here as commands have no way to report errors
+					_ = m.writeJSON(m.jsonWrite(name, val))
+					m.written++
+				}
+			}
 			continue
 		}
-		switch {
-		case val.String != nil:
-			fmt.Fprintln(m.out, *val.String)
-		case val.Node != nil:
-			fmt.Fprintln(m.out, string(val.Node.Range().SliceBytes(m.b)))
-		case val.ObjectConsItem != nil:
-		case val.Traverser != nil:
-			switch trav := (*val.Traverser).(type) {
-			case hcl.TraverseRoot:
-				fmt.Fprintln(m.out, trav.Name)
-			case hcl.TraverseAttr:
-				fmt.Fprintln(m.out, trav.Name)
-			default:
+		// The values are tab separated, the missing ones are empty. Nothing is printed if all are missing.
+		fields := make([]string, len(names))
+		var any bool
+		for i, name := range names {
+			val, ok := sub.values[name]
+			if !ok {
 				continue
 			}
-		default:
-			panic("never reach here")
+			if fields[i], ok = m.writtenValue(val); ok {
+				any = true
+			}
+		}
+		if any {
+			fmt.Fprintln(m.out, strings.Join(fields, "\t"))
+			m.written++
 		}
 	}
 
+	// The submatches are passed through, so that it can be used in the middle of the commands
 	return subs
 }
 
+// writtenValue returns the text of the recorded wildcard value printed by the "-w" command.
+func (m *Matcher) writtenValue(val substitution) (string, bool) {
+	switch {
+	case val.String != nil:
+		return *val.String, true
+	case val.Node != nil:
+		return string(val.Node.Range().SliceBytes(m.b)), true
+	case val.ObjectConsItem != nil:
+		return m.objectConsItemText(*val.ObjectConsItem), true
+	case val.List != nil:
+		return m.substitutionText(val), true
+	case val.Traverser != nil:
+		switch trav := (*val.Traverser).(type) {
+		case hcl.TraverseRoot:
+			return trav.Name, true
+		case hcl.TraverseAttr:
+			return trav.Name, true
+		default:
+			return "", false
+		}
+	default:
+		panic("never reach here")
+	}
+}
+
 func (m *Matcher) parentOf(node hclsyntax.Node) hclsyntax.Node {
 	return m.parents[node]
 }
@@ -347,14 +816,18 @@ func valsCopy(values map[string]substitution) map[string]substitution {
 }
 
 type substitution struct {
+	// the name recorded as string (e.g. a block label), with its source range if any
 	String         *string
+	StringRange    *hcl.Range
 	Node           hclsyntax.Node
 	ObjectConsItem *hclsyntax.ObjectConsItem
 	Traverser      *hcl.Traverser
+	// the elements matched by an any wildcard, which is non-nil even if it matches nothing
+	List []substitution
 }
 
-func newStringSubstitution(s string) substitution {
-	return substitution{String: &s}
+func newStringSubstitution(s string, rng *hcl.Range) substitution {
+	return substitution{String: &s, StringRange: rng}
 }
 
 func newNodeSubstitution(node hclsyntax.Node) substitution {
@@ -369,16 +842,29 @@ func newTraverserSubstitution(trav hcl.Traverser) substitution {
 	return substitution{Traverser: &trav}
 }
 
+func newListSubstitution(list []substitution) substitution {
+	if list == nil {
+		list = []substitution{}
+	}
+	return substitution{List: list}
+}
+
 func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 	if pattern == nil || node == nil {
 		return pattern == node
 	}
 
+	// A traversal with any wildcard steps is matched by the steps
+	if steps, ok := anyWildcardTraversalSteps(pattern); ok {
+		stepsY, ok := traversalSteps(node)
+		return ok && m.iterableMatches(stepIterable(steps), stepIterable(stepsY), wildNameFromStep, matchStep)
+	}
+
 	switch x := pattern.(type) {
 	// Expressions
 	case *hclsyntax.LiteralValueExpr:
 		y, ok := node.(*hclsyntax.LiteralValueExpr)
-		return ok && x.Val.Equals(y.Val).True()
+		return ok && x.Val.RawEquals(y.Val)
 	case *hclsyntax.TupleConsExpr:
 		y, ok := node.(*hclsyntax.TupleConsExpr)
 		return ok && m.exprs(x.Exprs, y.Exprs)
@@ -389,20 +875,25 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 		y, ok := node.(*hclsyntax.TemplateExpr)
 		return ok && m.exprs(x.Parts, y.Parts)
 	case *hclsyntax.FunctionCallExpr:
+		if x.Name == deepWildcardName && len(x.Args) == 1 {
+			return m.deep(x.Args[0], node)
+		}
 		y, ok := node.(*hclsyntax.FunctionCallExpr)
 		return ok &&
-			m.potentialWildcardIdentEqual(x.Name, y.Name) &&
+			m.potentialWildcardIdentEqual(x.Name, y.Name, &y.NameRange) &&
 			m.exprs(x.Args, y.Args) && x.ExpandFinal == y.ExpandFinal
 	case *hclsyntax.ForExpr:
 		y, ok := node.(*hclsyntax.ForExpr)
 		return ok &&
-			m.potentialWildcardIdentEqual(x.KeyVar, y.KeyVar) &&
-			m.potentialWildcardIdentEqual(x.ValVar, y.ValVar) &&
+			m.potentialWildcardIdentEqual(x.KeyVar, y.KeyVar, nil) &&
+			m.potentialWildcardIdentEqual(x.ValVar, y.ValVar, nil) &&
 			m.node(x.CollExpr, y.CollExpr) && m.node(x.KeyExpr, y.KeyExpr) && m.node(x.ValExpr, y.ValExpr) && m.node(x.CondExpr, y.CondExpr) && x.Group == y.Group
 	case *hclsyntax.IndexExpr:
 		// In case the index key of x is a wildcard, try to also match "y" even if it is not an IndexExpr
 		xname, ok := variableExpr(x.Key)
 		if ok && isWildName(xname) {
+			typ := wildType(xname)
+			xname, _ = fromWildName(xname)
 			switch y := node.(type) {
 			case *hclsyntax.ScopeTraversalExpr:
 				l := len(y.Traversal)
@@ -410,11 +901,11 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 					Traversal: make(hcl.Traversal, l-1),
 				}
 				copy(ySourceTraversal.Traversal, y.Traversal[:l-1])
-				return m.node(x.Collection, ySourceTraversal) && m.wildcardMatchTraverse(xname, y.Traversal[l-1])
+				return m.node(x.Collection, ySourceTraversal) && traverserTypeMatches(typ, y.Traversal[l-1]) && m.wildcardMatchTraverse(xname, y.Traversal[l-1])
 			case *hclsyntax.IndexExpr:
-				return m.node(x.Collection, y.Collection) && m.wildcardMatchNode(xname, y.Key)
+				return m.node(x.Collection, y.Collection) && nodeTypeMatches(typ, y.Key) && m.wildcardMatchNode(xname, y.Key)
 			case *hclsyntax.RelativeTraversalExpr:
-				return m.node(x.Collection, y.Source) && len(y.Traversal) == 1 && m.wildcardMatchTraverse(xname, y.Traversal[0])
+				return m.node(x.Collection, y.Source) && len(y.Traversal) == 1 && traverserTypeMatches(typ, y.Traversal[0]) && m.wildcardMatchTraverse(xname, y.Traversal[0])
 			default:
 				return false
 			}
@@ -442,7 +933,7 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 		xname, ok := variableExpr(x)
 		if ok && isWildName(xname) {
 			name, _ := fromWildName(xname)
-			return m.wildcardMatchNode(name, node)
+			return nodeTypeMatches(wildType(xname), node) && m.wildcardMatchNode(name, node)
 		}
 		y, ok := node.(*hclsyntax.ScopeTraversalExpr)
 		return ok && m.traversal(x.Traversal, y.Traversal)
@@ -471,6 +962,9 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 		return m.attribute(x, node)
 	// Block
 	case *hclsyntax.Block:
+		if x.Type == deepWildcardName && len(x.Labels) == 0 {
+			return m.deep(bodyContent(x.Body), node)
+		}
 		y, ok := node.(*hclsyntax.Block)
 		return ok && m.block(x, y)
 	default:
@@ -488,16 +982,38 @@ type wildNameFunc func(interface{}) (string, bool)
 type iterable interface {
 	at(i int) interface{}
 	len() int
+	// value returns the element as a wildcard value
+	value(i int) substitution
+}
+
+// label is a block label with its source range.
+type label struct {
+	name string
+	rng  hcl.Range
 }
 
-type stringIterable []string
+type labelIterable []label
 
-func (it stringIterable) at(i int) interface{} {
+func newLabelIterable(blk *hclsyntax.Block) labelIterable {
+	it := make(labelIterable, len(blk.Labels))
+	for i, name := range blk.Labels {
+		it[i] = label{name: name}
+		if i < len(blk.LabelRanges) {
+			it[i].rng = blk.LabelRanges[i]
+		}
+	}
+	return it
+}
+
+func (it labelIterable) at(i int) interface{} {
 	return it[i]
 }
-func (it stringIterable) len() int {
+func (it labelIterable) len() int {
 	return len(it)
 }
+func (it labelIterable) value(i int) substitution {
+	return newStringSubstitution(it[i].name, &it[i].rng)
+}
 
 type nodeIterable []hclsyntax.Node
 
@@ -509,6 +1025,10 @@ func (it nodeIterable) len() int {
 	return len(it)
 }
 
+func (it nodeIterable) value(i int) substitution {
+	return newNodeSubstitution(it[i])
+}
+
 type exprIterable []hclsyntax.Expression
 
 func (it exprIterable) at(i int) interface{} {
@@ -519,6 +1039,10 @@ func (it exprIterable) len() int {
 	return len(it)
 }
 
+func (it exprIterable) value(i int) substitution {
+	return newNodeSubstitution(it[i])
+}
+
 type objectConsItemIterable []hclsyntax.ObjectConsItem
 
 func (it objectConsItemIterable) at(i int) interface{} {
@@ -529,11 +1053,19 @@ func (it objectConsItemIterable) len() int {
 	return len(it)
 }
 
-// iterableMatches matches two lists. It uses a common algorithm to match
-// wildcard patterns with any number of elements without recursion.
+func (it objectConsItemIterable) value(i int) substitution {
+	return newObjectConsItemSubstitution(&it[i])
+}
+
+// iterableMatches matches two lists, where a wildcard pattern with any
+// number of elements can be used.
+// The elements matched by an any wildcard are recorded as a list.
 func (m *Matcher) iterableMatches(ns1, ns2 iterable, nf wildNameFunc, mf matchFunc) bool {
 	i1, i2 := 0, 0
 	next1, next2 := 0, 0
+	// the start of the elements matched by the any wildcard at next1, which is kept on restart
+	anyStart := 0
+	restarted := false
 
 	// We need to keep a copy of m.values so that we can restart
 	// with a different "any of" match while discarding any matches
@@ -546,17 +1078,36 @@ func (m *Matcher) iterableMatches(ns1, ns2 iterable, nf wildNameFunc, mf matchFu
 		}
 	}
 	backupMatches()
+	initial := oldMatches
 
 	for i1 < ns1.len() || i2 < ns2.len() {
 		if i1 < ns1.len() {
 			n1 := ns1.at(i1)
-			if _, any := nf(n1); any {
+			if name, any := nf(n1); any {
+				if _, ok := m.values[name]; ok {
+					// The elements must equal the ones recorded before, which needs backtracking across all
+					// the any wildcards
+					m.values = initial
+					return m.iterableMatchesFrom(ns1, ns2, 0, 0, nf, mf)
+				}
 				// try to match zero or more at i2,
 				// restarting at i2+1 if it fails
+				if !restarted {
+					anyStart = i2
+				}
+				restarted = false
 				next1 = i1
 				next2 = i2 + 1
 				i1++
+				if i1 == ns1.len() {
+					// the last any wildcard matches all the rest
+					i2 = ns2.len()
+					next2 = i2 + 1
+				}
 				backupMatches()
+				if name != "_" {
+					m.values[name] = newListSubstitution(iterableValues(ns2, anyStart, i2))
+				}
 				continue
 			}
 			if i2 < ns2.len() && mf(m, n1, ns2.at(i2)) {
@@ -571,6 +1122,7 @@ func (m *Matcher) iterableMatches(ns1, ns2 iterable, nf wildNameFunc, mf matchFu
 			i1 = next1
 			i2 = next2
 			m.values = oldMatches
+			restarted = true
 			continue
 		}
 		return false
@@ -578,6 +1130,42 @@ func (m *Matcher) iterableMatches(ns1, ns2 iterable, nf wildNameFunc, mf matchFu
 	return true
 }
 
+// iterableMatchesFrom matches the elements of ns1 since i1 against the elements of ns2 since i2. An any wildcard
+// tries to match zero or more elements, and backtracks to match one more element if the rest doesn't match, with
+// the values recorded since then discarded. This allows the same named any wildcard to be repeated, e.g. "[$*a, $*a]".
+func (m *Matcher) iterableMatchesFrom(ns1, ns2 iterable, i1, i2 int, nf wildNameFunc, mf matchFunc) bool {
+	for ; i1 < ns1.len(); i1++ {
+		n1 := ns1.at(i1)
+		name, any := nf(n1)
+		if !any {
+			if i2 == ns2.len() || !mf(m, n1, ns2.at(i2)) {
+				return false
+			}
+			i2++
+			continue
+		}
+		for end := i2; end <= ns2.len(); end++ {
+			values := valsCopy(m.values)
+			if (name == "_" || m.wildcardMatch(name, newListSubstitution(iterableValues(ns2, i2, end)))) &&
+				m.iterableMatchesFrom(ns1, ns2, i1+1, end, nf, mf) {
+				return true
+			}
+			m.values = values
+		}
+		return false
+	}
+	return i2 == ns2.len()
+}
+
+// iterableValues returns the values of the elements of ns from start to end (exclusive).
+func iterableValues(ns iterable, start, end int) []substitution {
+	list := make([]substitution, 0, end-start)
+	for i := start; i < end; i++ {
+		list = append(list, ns.value(i))
+	}
+	return list
+}
+
 // Node comparisons
 
 func wildNameFromNode(in interface{}) (string, bool) {
@@ -615,31 +1203,166 @@ func (m *Matcher) attribute(x *hclsyntax.Attribute, y hclsyntax.Node) bool {
 			return false
 		}
 	}
+	if blk, ok := y.(*hclsyntax.Block); ok && m.jsonObjects[blk] != nil {
+		// An object valued property of the JSON syntax can also be an attribute
+		return m.node(x.Expr, m.jsonObjects[blk]) &&
+			m.potentialWildcardIdentEqual(x.Name, blk.Type, &blk.TypeRange)
+	}
 	attrY, ok := y.(*hclsyntax.Attribute)
 	return ok && m.node(x.Expr, attrY.Expr) &&
-		m.potentialWildcardIdentEqual(x.Name, attrY.Name)
+		m.potentialWildcardIdentEqual(x.Name, attrY.Name, &attrY.NameRange)
 }
 
 func (m *Matcher) block(x, y *hclsyntax.Block) bool {
 	if x == nil || y == nil {
 		return x == y
 	}
-	return m.potentialWildcardIdentEqual(x.Type, y.Type) &&
-		m.potentialWildcardIdentsEqual(x.Labels, y.Labels) &&
+	if m.jsonObjects != nil && len(x.Labels) != 0 && len(y.Labels) == 0 {
+		return m.jsonBlock(x, y)
+	}
+	return m.potentialWildcardIdentEqual(x.Type, y.Type, &y.TypeRange) &&
+		m.iterableMatches(newLabelIterable(x), newLabelIterable(y), wildNameFromLabel, matchLabel) &&
 		m.body(x.Body, y.Body)
 }
 
+// jsonBlock matches a block with labels against a block of the JSON syntax, where the labels are represented by the
+// nested blocks, e.g. {"resource": {"foo": {"bar": {}}}}.
+// At the top level, the innermost block is matched, with its ancestors providing the type and the preceding labels,
+// so that each of the sibling blocks is a separate match. Inside a body, the outermost block is matched, which has
+// any of its nested blocks matching the labels.
+func (m *Matcher) jsonBlock(x, y *hclsyntax.Block) bool {
+	names := append([]string{x.Type}, x.Labels...)
+	if m.bodyDepth > 0 {
+		return m.jsonNestedBlocks(names, x.Body, y)
+	}
+	blocks := []*hclsyntax.Block{y}
+	for len(blocks) < len(names) {
+		body, ok := m.parentOf(blocks[0]).(*hclsyntax.Body)
+		if !ok {
+			return false
+		}
+		parent, ok := m.parentOf(body).(*hclsyntax.Block)
+		if !ok {
+			return false
+		}
+		blocks = append([]*hclsyntax.Block{parent}, blocks...)
+	}
+	for i, blk := range blocks {
+		if !m.potentialWildcardIdentEqual(names[i], blk.Type, &blk.TypeRange) {
+			return false
+		}
+	}
+	return m.body(x.Body, y.Body)
+}
+
+func (m *Matcher) jsonNestedBlocks(names []string, x *hclsyntax.Body, y *hclsyntax.Block) bool {
+	if !m.potentialWildcardIdentEqual(names[0], y.Type, &y.TypeRange) {
+		return false
+	}
+	if len(names) == 1 {
+		return m.body(x, y.Body)
+	}
+	for _, blk := range sortBody(y.Body) {
+		blk, ok := blk.(*hclsyntax.Block)
+		if !ok {
+			continue
+		}
+		values := valsCopy(m.values)
+		if m.jsonNestedBlocks(names[1:], x, blk) {
+			return true
+		}
+		m.values = values
+	}
+	return false
+}
+
 func (m *Matcher) body(x, y *hclsyntax.Body) bool {
 	if x == nil || y == nil {
 		return x == y
 	}
+	m.bodyDepth++
+	defer func() { m.bodyDepth-- }()
 
 	// Sort the attributes/blocks to reserve the order in source
 	bodyEltsX := sortBody(x)
 	bodyEltsY := sortBody(y)
+
+	unordered := m.unordered
+	if _, ok := x.Attributes[unorderedMarkerName]; ok {
+		unordered = true
+		for i, elt := range bodyEltsX {
+			if attr, ok := elt.(*hclsyntax.Attribute); ok && attr.Name == unorderedMarkerName {
+				bodyEltsX = append(bodyEltsX[:i:i], bodyEltsX[i+1:]...)
+				break
+			}
+		}
+	}
+	if unordered {
+		return m.unorderedBody(bodyEltsX, bodyEltsY)
+	}
 	return m.iterableMatches(nodeIterable(bodyEltsX), nodeIterable(bodyEltsY), wildNameFromNode, matchNode)
 }
 
+// unorderedBody matches the attributes/blocks of two bodies in any order. Each of the pattern must match a distinct
+// one of the target, while the remaining ones of the target are absorbed by the first any wildcard (the other any
+// wildcards match nothing). Without any wildcard, there must be no remaining one.
+func (m *Matcher) unorderedBody(eltsX, eltsY []hclsyntax.Node) bool {
+	var fixed []hclsyntax.Node
+	var anyNames []string
+	for _, elt := range eltsX {
+		if name, any := wildNameFromNode(elt); any {
+			anyNames = append(anyNames, name)
+			continue
+		}
+		fixed = append(fixed, elt)
+	}
+	used := make([]bool, len(eltsY))
+
+	var assign func(i int) bool
+	assign = func(i int) bool {
+		if i < len(fixed) {
+			for j, elt := range eltsY {
+				if used[j] {
+					continue
+				}
+				values := valsCopy(m.values)
+				if m.node(fixed[i], elt) {
+					used[j] = true
+					if assign(i + 1) {
+						return true
+					}
+					used[j] = false
+				}
+				m.values = values
+			}
+			return false
+		}
+
+		var rest []substitution
+		for j, elt := range eltsY {
+			if !used[j] {
+				rest = append(rest, newNodeSubstitution(elt))
+			}
+		}
+		if len(anyNames) == 0 {
+			return len(rest) == 0
+		}
+		values := valsCopy(m.values)
+		for k, name := range anyNames {
+			list := rest
+			if k > 0 {
+				list = nil
+			}
+			if !m.wildcardMatch(name, newListSubstitution(list)) {
+				m.values = values
+				return false
+			}
+		}
+		return true
+	}
+	return assign(0)
+}
+
 func (m *Matcher) exprs(exprs1, exprs2 []hclsyntax.Expression) bool {
 	return m.iterableMatches(exprIterable(exprs1), exprIterable(exprs2), wildNameFromNode, matchNode)
 }
@@ -675,7 +1398,8 @@ func (m *Matcher) objectConsItem(item1, item2 hclsyntax.ObjectConsItem) bool {
 	if key1, ok := item1.KeyExpr.(*hclsyntax.ObjectConsKeyExpr); ok {
 		name, ok := variableExpr(key1.Wrapped)
 		if ok && isWildAttr(name, item1.ValueExpr) {
-			return m.wildcardMatchObjectConsItem(name, item2)
+			ident, _ := fromWildName(name)
+			return m.wildcardMatchObjectConsItem(ident, item2)
 		}
 	}
 	return m.node(item1.KeyExpr, item2.KeyExpr) && m.node(item1.ValueExpr, item2.ValueExpr)
@@ -687,25 +1411,27 @@ func (m *Matcher) objectConsItems(items1, items2 []hclsyntax.ObjectConsItem) boo
 
 // String comparisons
 
-func wildNameFromString(in interface{}) (string, bool) {
-	return fromWildName(in.(string))
+func wildNameFromLabel(in interface{}) (string, bool) {
+	return fromWildName(in.(label).name)
 }
 
-func matchString(m *Matcher, x, y interface{}) bool {
-	sx, sy := x.(string), y.(string)
-	return m.potentialWildcardIdentEqual(sx, sy)
+func matchLabel(m *Matcher, x, y interface{}) bool {
+	lx, ly := x.(label), y.(label)
+	if !isWildName(lx.name) {
+		return lx.name == ly.name
+	}
+	name, _ := fromWildName(lx.name)
+	return m.wildcardMatch(name, newStringSubstitution(ly.name, &ly.rng))
 }
 
-func (m *Matcher) potentialWildcardIdentEqual(identX, identY string) bool {
+// potentialWildcardIdentEqual matches the name by the pattern name, which is possibly a wildcard. The range of the
+// name is recorded together with it, if any.
+func (m *Matcher) potentialWildcardIdentEqual(identX, identY string, rngY *hcl.Range) bool {
 	if !isWildName(identX) {
 		return identX == identY
 	}
 	name, _ := fromWildName(identX)
-	return m.wildcardMatchString(name, identY)
-}
-
-func (m *Matcher) potentialWildcardIdentsEqual(identX, identY []string) bool {
-	return m.iterableMatches(stringIterable(identX), stringIterable(identY), wildNameFromString, matchString)
+	return m.wildcardMatchString(name, identY, rngY)
 }
 
 // Traversal comparisons
@@ -726,13 +1452,22 @@ func (m *Matcher) traverser(t1, t2 hcl.Traverser) bool {
 	switch t1 := t1.(type) {
 	case hcl.TraverseRoot:
 		t2, ok := t2.(hcl.TraverseRoot)
-		return ok && m.potentialWildcardIdentEqual(t1.Name, t2.Name)
+		return ok && m.potentialWildcardIdentEqual(t1.Name, t2.Name, &t2.SrcRange)
 	case hcl.TraverseAttr:
 		t2, ok := t2.(hcl.TraverseAttr)
-		return ok && m.potentialWildcardIdentEqual(t1.Name, t2.Name)
+		if !ok {
+			return false
+		}
+		// The range of the name excludes the leading dot
+		rng := t2.SrcRange
+		if rng.End.Byte-rng.Start.Byte == len(t2.Name)+1 {
+			rng.Start.Byte++
+			rng.Start.Column++
+		}
+		return m.potentialWildcardIdentEqual(t1.Name, t2.Name, &rng)
 	case hcl.TraverseIndex:
 		t2, ok := t2.(hcl.TraverseIndex)
-		return ok && t1.Key.Equals(t2.Key).True()
+		return ok && t1.Key.RawEquals(t2.Key)
 	case hcl.TraverseSplat:
 		t2, ok := t2.(hcl.TraverseSplat)
 		return ok && m.traversal(t1.Each, t2.Each)
@@ -741,123 +1476,387 @@ func (m *Matcher) traverser(t1, t2 hcl.Traverser) bool {
 	}
 }
 
-// Wildcard matchers
+// Traversal step comparisons
 
-func (m *Matcher) wildcardMatchNode(name string, node hclsyntax.Node) bool {
-	// Wildcard never matches multiple attributes/blocks.
-	// On one hand, it is because we have any wildcard, which already meets this requirement.
-	// One the other hand, Go panics to use the attributes/blocks slice as map key.
-	switch node.(type) {
-	case hclsyntax.Attributes,
-		hclsyntax.Blocks:
-		return false
+// traversalStep is a step of a traversal expression, which is either a traverser, or an index or a splat of the
+// expression. The latter is represented by an IndexExpr or a SplatExpr relative to an AnonSymbolExpr, which ranges the
+// step alone, e.g. "[count.index]" or "[*]".
+type traversalStep struct {
+	traverser hcl.Traverser
+	expr      hclsyntax.Expression
+}
+
+type stepIterable []traversalStep
+
+func (it stepIterable) at(i int) interface{} {
+	return it[i]
+}
+
+func (it stepIterable) len() int {
+	return len(it)
+}
+
+func (it stepIterable) value(i int) substitution {
+	if it[i].traverser != nil {
+		return newTraverserSubstitution(it[i].traverser)
 	}
+	return newNodeSubstitution(it[i].expr)
+}
 
-	if name == "_" {
-		// values are discarded, matches anything
-		return true
+// traversalSteps flattens the chain of the traversals, index expressions and splat expressions, which starts from
+// a root variable, into the steps.
+func traversalSteps(node hclsyntax.Node) ([]traversalStep, bool) {
+	return splatTraversalSteps(node, false)
+}
+
+// splatTraversalSteps is traversalSteps, which also accepts the anonymous symbol as the root while flattening the
+// steps that follow a splat. Otherwise, the steps of a splat would also match on their own.
+func splatTraversalSteps(node hclsyntax.Node, inSplat bool) ([]traversalStep, bool) {
+	switch node := node.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		return traverserSteps(nil, node.Traversal), true
+	case *hclsyntax.RelativeTraversalExpr:
+		steps, ok := splatTraversalSteps(node.Source, inSplat)
+		return traverserSteps(steps, node.Traversal), ok
+	case *hclsyntax.IndexExpr:
+		steps, ok := splatTraversalSteps(node.Collection, inSplat)
+		if !ok {
+			return nil, false
+		}
+		// The literal index key can also be a traverser
+		if lit, ok := node.Key.(*hclsyntax.LiteralValueExpr); ok {
+			return append(steps, traversalStep{traverser: hcl.TraverseIndex{Key: lit.Val, SrcRange: node.BracketRange}}), true
+		}
+		return append(steps, traversalStep{expr: &hclsyntax.IndexExpr{
+			Collection:   anonSymbolAt(node.OpenRange),
+			Key:          node.Key,
+			SrcRange:     node.BracketRange,
+			OpenRange:    node.OpenRange,
+			BracketRange: node.BracketRange,
+		}}), true
+	case *hclsyntax.SplatExpr:
+		steps, ok := splatTraversalSteps(node.Source, inSplat)
+		if !ok {
+			return nil, false
+		}
+		steps = append(steps, traversalStep{expr: &hclsyntax.SplatExpr{
+			Source:      anonSymbolAt(node.MarkerRange),
+			Each:        anonSymbolAt(node.MarkerRange),
+			Item:        anonSymbolAt(node.MarkerRange),
+			SrcRange:    node.MarkerRange,
+			MarkerRange: node.MarkerRange,
+		}})
+		eachSteps, ok := splatTraversalSteps(node.Each, true)
+		return append(steps, eachSteps...), ok
+	case *hclsyntax.AnonSymbolExpr:
+		return nil, inSplat
+	default:
+		return nil, false
 	}
-	prev, ok := m.values[name]
-	if !ok {
-		m.values[name] = newNodeSubstitution(node)
-		return true
+}
+
+func traverserSteps(steps []traversalStep, traversal hcl.Traversal) []traversalStep {
+	for _, trav := range traversal {
+		steps = append(steps, traversalStep{traverser: trav})
 	}
-	switch {
-	case prev.String != nil:
-		nodeVar, ok := variableExpr(node)
-		return ok && nodeVar == *prev.String
-	case prev.Node != nil:
-		return m.node(prev.Node, node)
-	case prev.ObjectConsItem != nil:
-		return false
+	return steps
+}
+
+func anonSymbolAt(rng hcl.Range) *hclsyntax.AnonSymbolExpr {
+	return &hclsyntax.AnonSymbolExpr{SrcRange: hcl.Range{Filename: rng.Filename, Start: rng.Start, End: rng.Start}}
+}
+
+// anyWildcardTraversalSteps returns the steps of the traversal pattern, if any of them is an any wildcard, e.g.
+// "module.$*_.id".
+func anyWildcardTraversalSteps(pattern hclsyntax.Node) ([]traversalStep, bool) {
+	switch pattern.(type) {
+	case *hclsyntax.ScopeTraversalExpr,
+		*hclsyntax.RelativeTraversalExpr,
+		*hclsyntax.IndexExpr,
+		*hclsyntax.SplatExpr:
 	default:
-		panic("never reach here")
+		return nil, false
 	}
+	steps, ok := traversalSteps(pattern)
+	// A single any wildcard is an expression wildcard
+	if !ok || len(steps) < 2 {
+		return nil, false
+	}
+	for _, step := range steps {
+		if _, any := wildNameFromStep(step); any {
+			return steps, true
+		}
+	}
+	return nil, false
 }
 
-func (m *Matcher) wildcardMatchString(name, target string) bool {
-	if name == "_" {
-		// values are discarded, matches anything
-		return true
+func wildNameFromStep(in interface{}) (string, bool) {
+	var name string
+	switch trav := in.(traversalStep).traverser.(type) {
+	case hcl.TraverseRoot:
+		name = trav.Name
+	case hcl.TraverseAttr:
+		name = trav.Name
+	default:
+		return "", false
 	}
-	prev, ok := m.values[name]
-	if !ok {
-		m.values[name] = newStringSubstitution(target)
-		return true
+	if !isWildName(name) {
+		return "", false
 	}
+	return fromWildName(name)
+}
 
+func matchStep(m *Matcher, x, y interface{}) bool {
+	stepX, stepY := x.(traversalStep), y.(traversalStep)
 	switch {
-	case prev.String != nil:
-		return *prev.String == target
-	case prev.Node != nil:
-		prevName, ok := variableExpr(prev.Node)
-		return ok && prevName == target
-	case prev.ObjectConsItem != nil:
-		return false
-	case prev.Traverser != nil:
-		switch trav := (*prev.Traverser).(type) {
-		case hcl.TraverseRoot:
-			return trav.Name == target
-		case hcl.TraverseAttr:
-			return trav.Name == target
+	case stepX.traverser != nil && stepY.traverser != nil:
+		return m.traverser(stepX.traverser, stepY.traverser)
+	case stepX.expr != nil && stepY.expr != nil:
+		return m.node(stepX.expr, stepY.expr)
+	case stepX.traverser != nil:
+		// The legacy splat traverser, e.g. "foo.*.bar"
+		_, isSplat := stepX.traverser.(hcl.TraverseSplat)
+		_, isSplatY := stepY.expr.(*hclsyntax.SplatExpr)
+		return isSplat && isSplatY
+	default:
+		// The index wildcard can also match the traverser, e.g. "foo[$x]" matches "foo.bar"
+		switch x := stepX.expr.(type) {
+		case *hclsyntax.IndexExpr:
+			xname, ok := variableExpr(x.Key)
+			if !ok || !isWildName(xname) {
+				return false
+			}
+			if _, isRoot := stepY.traverser.(hcl.TraverseRoot); isRoot {
+				return false
+			}
+			typ := wildType(xname)
+			xname, _ = fromWildName(xname)
+			return traverserTypeMatches(typ, stepY.traverser) && m.wildcardMatchTraverse(xname, stepY.traverser)
+		case *hclsyntax.SplatExpr:
+			_, isSplat := stepY.traverser.(hcl.TraverseSplat)
+			return isSplat
 		default:
 			return false
 		}
-	default:
-		panic("never reach here")
 	}
 }
 
-func (m *Matcher) wildcardMatchObjectConsItem(name string, item hclsyntax.ObjectConsItem) bool {
-	if name == "_" {
-		// values are discarded, matches anything
+// Wildcard matchers
+
+// wildTypes are the types of the typed expression wildcards, by which the expressions are accepted, e.g. $x:string.
+var wildTypes = map[string]func(hclsyntax.Node) bool{
+	"string": func(node hclsyntax.Node) bool {
+		switch node := node.(type) {
+		case *hclsyntax.LiteralValueExpr:
+			return node.Val.Type() == cty.String
+		case *hclsyntax.TemplateExpr:
+			// A string without interpolation
+			for _, part := range node.Parts {
+				if lit, ok := part.(*hclsyntax.LiteralValueExpr); !ok || lit.Val.Type() != cty.String {
+					return false
+				}
+			}
+			return true
+		default:
+			return false
+		}
+	},
+	"number": func(node hclsyntax.Node) bool {
+		// A negative number is a negation of the number
+		if op, ok := node.(*hclsyntax.UnaryOpExpr); ok && op.Op == hclsyntax.OpNegate {
+			node = op.Val
+		}
+		lit, ok := node.(*hclsyntax.LiteralValueExpr)
+		return ok && lit.Val.Type() == cty.Number
+	},
+	"bool": func(node hclsyntax.Node) bool {
+		lit, ok := node.(*hclsyntax.LiteralValueExpr)
+		return ok && lit.Val.Type() == cty.Bool
+	},
+	"traversal": func(node hclsyntax.Node) bool {
+		_, ok := traversalSteps(node)
+		return ok
+	},
+	"call": func(node hclsyntax.Node) bool {
+		_, ok := node.(*hclsyntax.FunctionCallExpr)
+		return ok
+	},
+	"tuple": func(node hclsyntax.Node) bool {
+		_, ok := node.(*hclsyntax.TupleConsExpr)
+		return ok
+	},
+	"object": func(node hclsyntax.Node) bool {
+		_, ok := node.(*hclsyntax.ObjectConsExpr)
+		return ok
+	},
+	"template": func(node hclsyntax.Node) bool {
+		switch node.(type) {
+		case *hclsyntax.TemplateExpr,
+			*hclsyntax.TemplateWrapExpr,
+			*hclsyntax.TemplateJoinExpr:
+			return true
+		default:
+			return false
+		}
+	},
+}
+
+// wildTypeNames returns the sorted names of the wildcard types.
+func wildTypeNames() []string {
+	names := make([]string, 0, len(wildTypes))
+	for name := range wildTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nodeTypeMatches checks whether the node is accepted by the type of the wildcard, if any.
+func nodeTypeMatches(typ string, node hclsyntax.Node) bool {
+	if typ == "" {
 		return true
 	}
-	prev, ok := m.values[name]
-	if !ok {
-		m.values[name] = newObjectConsItemSubstitution(&item)
+	return wildTypes[typ](node)
+}
+
+// traverserTypeMatches checks whether the traverser is accepted by the type of the wildcard, if any, which is
+// only the case of the index of a string or a number.
+func traverserTypeMatches(typ string, trav hcl.Traverser) bool {
+	if typ == "" {
 		return true
 	}
-	switch {
-	case prev.String != nil:
+	index, ok := trav.(hcl.TraverseIndex)
+	if !ok {
 		return false
-	case prev.Node != nil:
+	}
+	switch typ {
+	case "string":
+		return index.Key.Type() == cty.String
+	case "number":
+		return index.Key.Type() == cty.Number
+	default:
 		return false
-	case prev.ObjectConsItem != nil:
-		return m.objectConsItem(*prev.ObjectConsItem, item)
-	case prev.Traverser != nil:
+	}
+}
+
+// deep matches the pattern of the deep wildcard against the node and all its descendants. The values are kept to be
+// the state of the first match (DFS).
+func (m *Matcher) deep(pattern, node hclsyntax.Node) bool {
+	values := m.values
+	var any bool
+	hclsyntax.VisitAll(node, func(node hclsyntax.Node) hcl.Diagnostics {
+		if any {
+			return nil
+		}
+		m.values = valsCopy(values)
+		any = m.node(pattern, node)
+		return nil
+	})
+	if !any {
+		m.values = values
+	}
+	return any
+}
+
+func (m *Matcher) wildcardMatchNode(name string, node hclsyntax.Node) bool {
+	// Wildcard never matches multiple attributes/blocks.
+	// On one hand, it is because we have any wildcard, which already meets this requirement.
+	// One the other hand, Go panics to use the attributes/blocks slice as map key.
+	switch node.(type) {
+	case hclsyntax.Attributes,
+		hclsyntax.Blocks:
 		return false
-	default:
-		panic("never reach here")
 	}
+	return m.wildcardMatch(name, newNodeSubstitution(node))
+}
+
+func (m *Matcher) wildcardMatchString(name, target string, rng *hcl.Range) bool {
+	return m.wildcardMatch(name, newStringSubstitution(target, rng))
+}
+
+func (m *Matcher) wildcardMatchObjectConsItem(name string, item hclsyntax.ObjectConsItem) bool {
+	return m.wildcardMatch(name, newObjectConsItemSubstitution(&item))
 }
 
 func (m *Matcher) wildcardMatchTraverse(name string, trav hcl.Traverser) bool {
+	return m.wildcardMatch(name, newTraverserSubstitution(trav))
+}
+
+// wildcardMatch records the value for the wildcard name, or checks it against the value recorded before.
+func (m *Matcher) wildcardMatch(name string, val substitution) bool {
 	if name == "_" {
 		// values are discarded, matches anything
 		return true
 	}
 	prev, ok := m.values[name]
 	if !ok {
-		m.values[name] = newTraverserSubstitution(trav)
+		m.values[name] = val
 		return true
 	}
+	return m.valueMatches(prev, val)
+}
+
+// valueMatches checks whether the value matches the value recorded before for the same wildcard name.
+func (m *Matcher) valueMatches(prev, val substitution) bool {
 	switch {
-	case prev.String != nil:
-		switch trav := trav.(type) {
-		case hcl.TraverseRoot:
-			return trav.Name == *prev.String
-		case hcl.TraverseAttr:
-			return trav.Name == *prev.String
+	case val.String != nil:
+		target := *val.String
+		switch {
+		case prev.String != nil:
+			return *prev.String == target
+		case prev.Node != nil:
+			prevName, ok := variableExpr(prev.Node)
+			return ok && prevName == target
+		case prev.Traverser != nil:
+			switch trav := (*prev.Traverser).(type) {
+			case hcl.TraverseRoot:
+				return trav.Name == target
+			case hcl.TraverseAttr:
+				return trav.Name == target
+			default:
+				return false
+			}
 		default:
 			return false
 		}
-	case prev.Node != nil:
-		return false
-	case prev.ObjectConsItem != nil:
-		return false
-	case prev.Traverser != nil:
-		return m.traverser(trav, *prev.Traverser)
+	case val.Node != nil:
+		switch {
+		case prev.String != nil:
+			nodeVar, ok := variableExpr(val.Node)
+			return ok && nodeVar == *prev.String
+		case prev.Node != nil:
+			return m.node(prev.Node, val.Node)
+		default:
+			return false
+		}
+	case val.ObjectConsItem != nil:
+		return prev.ObjectConsItem != nil && m.objectConsItem(*prev.ObjectConsItem, *val.ObjectConsItem)
+	case val.Traverser != nil:
+		switch {
+		case prev.String != nil:
+			switch trav := (*val.Traverser).(type) {
+			case hcl.TraverseRoot:
+				return trav.Name == *prev.String
+			case hcl.TraverseAttr:
+				return trav.Name == *prev.String
+			default:
+				return false
+			}
+		case prev.Traverser != nil:
+			return m.traverser(*val.Traverser, *prev.Traverser)
+		default:
+			return false
+		}
+	case val.List != nil:
+		if prev.List == nil || len(prev.List) != len(val.List) {
+			return false
+		}
+		for i := range val.List {
+			if !m.valueMatches(prev.List[i], val.List[i]) {
+				return false
+			}
+		}
+		return true
 	default:
 		panic("never reach here")
 	}
@@ -868,14 +1867,18 @@ func (m *Matcher) wildcardMatchTraverse(name string, trav hcl.Traverser) bool {
 // - expression wildcard (any): $<ident> => hclgrep_any_<ident>
 // - attribute wildcard : @<ident> => hclgrep-<index>_<ident> = hclgrepattr
 // - attribute wildcard (any) : @<ident> => hclgrep_any-<index>_<ident> = hclgrepattr
+// - expression wildcard (typed): $<ident>:<type> => hclgrep_<ident>-<type>
+// - expression wildcard (deep): $**(<expression>) => hclgrepdeep(<expression>)
+// - attribute wildcard (deep): @**{<body>} => hclgrepdeep{<body>}
+// - unordered body marker: @~ => hclgrepunordered = hclgrepattr
 const (
-	wildPrefix    = "hclgrep_"
-	wildExtraAny  = "any_"
-	wildAttrValue = "hclgrepattr"
+	wildPrefix          = "hclgrep_"
+	wildExtraAny        = "any_"
+	wildAttrValue       = "hclgrepattr"
+	deepWildcardName    = "hclgrepdeep"
+	unorderedMarkerName = "hclgrepunordered"
 )
 
-var wildattrCounters = map[string]int{}
-
 func wildName(name string, any bool) string {
 	prefix := wildPrefix
 	if any {
@@ -884,10 +1887,27 @@ func wildName(name string, any bool) string {
 	return prefix + name
 }
 
-func wildAttr(name string, any bool) string {
-	attr := wildName(name, any) + "-" + strconv.Itoa(wildattrCounters[name]) + "=" + wildAttrValue
-	wildattrCounters[name] += 1
-	return attr
+// wildAttr returns the attribute for the attribute wildcard. The index is used to make attributes of the
+// same wildcard name unique inside one pattern.
+func wildAttr(name string, any bool, index int) string {
+	return wildName(name, any) + "-" + strconv.Itoa(index) + "=" + wildAttrValue
+}
+
+func wildTypedName(name, typ string) string {
+	return wildName(name, false) + "-" + typ
+}
+
+// wildType returns the type of the typed expression wildcard, or empty for the others.
+func wildType(name string) string {
+	if !isWildName(name) {
+		return ""
+	}
+	if parts := strings.SplitN(name, "-", 2); len(parts) == 2 {
+		if _, ok := wildTypes[parts[1]]; ok {
+			return parts[1]
+		}
+	}
+	return ""
 }
 
 func isWildName(name string) bool {
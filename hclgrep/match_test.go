@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/hashicorp/hcl/v2"
@@ -82,6 +85,23 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "[$*_, 1]"}, "[1, 2, 3]", 0},
 		{[]string{"-x", "[$*_]"}, "[]", 1},
 		{[]string{"-x", "[$*_, $x]"}, "[1, 2, 3]", 1},
+		{[]string{"-x", "[$*a, 0, $*a]"}, "[1, 2, 0, 1, 2]", 1},
+		{[]string{"-x", "[$*a, 0, $*a]"}, "[1, 2, 0, 1, 3]", 0},
+		{[]string{"-x", "[$*a, 0, $*a]"}, "[0]", 1},
+		{[]string{"-x", "[[$*a], [$*a]]"}, "[[1, 2], [1, 2]]", 1},
+		{[]string{"-x", "[[$*a], [$*a]]"}, "[[1, 2], [1]]", 0},
+		{[]string{"-x", "[[$*a], $a]"}, "[[1], 1]", 0},
+		{[]string{"-x", "[$*a, $*a]"}, "[1, 1]", 1},
+		{[]string{"-x", "[$*a, $*a]"}, "[1, 2, 1, 2]", 1},
+		{[]string{"-x", "[$*a, $*a]"}, "[1, 2, 1]", 0},
+		{[]string{"-x", "[$*a, $*b, $*a]"}, "[1, 2, 3, 1]", 1},
+		{[]string{"-x", "[[$*a], $*_, $*a, 5]"}, "[[7], 1, 7, 5]", 1},
+		{[]string{"-x", "[[$*a], $*_, $*a, 5]"}, "[[7], 1, 7, 1, 5]", 0},
+		// the any wildcards without a recorded value are matched linearly
+		{[]string{"-x", "[$*_, 1, $*_, 2, $*_, 3, $*_, 42]"}, "[" + strings.Repeat("0, 1, 2, 3, ", 100) + "4]", 0},
+		{[]string{"-x", "[$*_, 1, $*_, 2, $*_, 3, $*_, 42]"}, "[" + strings.Repeat("0, 1, 2, 3, ", 100) + "42]", 1},
+		{[]string{"-x", "foo($*a, $*a)"}, "foo(1, 2, 1, 2)", 1},
+		{[]string{"-x", "foo($*a, $*a)"}, "foo(1, 2, 2, 1)", 0},
 
 		// object const expression
 		{[]string{"-x", "{a = b}"}, "{a = b}", 1},
@@ -100,6 +120,10 @@ func TestMatch(t *testing.T) {
 			want: 1,
 		},
 
+		// literal object key is not matched by its name
+		{[]string{"-x", "a"}, "{a = 1}", 0},
+		{[]string{"-x", "$_"}, "{a = 1}", 3},
+
 		// object const expression (wildcard)
 		{[]string{"-x", "x = $_"}, "x = {a = b}", 1},
 		{[]string{"-x", "{$x = $x}"}, "{a = a}", 1},
@@ -333,6 +357,25 @@ EOF
 		{[]string{"-x", "a.$x.$_.$x"}, "a.x.y.x", 1},
 		{[]string{"-x", "$_.$x.$_.$x"}, "a.x.y.x", 1},
 		{[]string{"-x", "a.$x.$*_.$x"}, "a.x.y.z", 0},
+		{[]string{"-x", "a.$x.$*_.$x"}, "a.x.y.z.x", 1},
+		{[]string{"-x", "a.$*_.id"}, "a.id", 1},
+		{[]string{"-x", "a.$*_.id"}, "a.b.c.id", 1},
+		{[]string{"-x", "a.$*_.id"}, "a.b.c.name", 0},
+		{[]string{"-x", "a.$*_.id"}, "b.c.id", 0},
+		{[]string{"-x", "a.$*_.id"}, "a.b[0].id", 1},
+		{[]string{"-x", "a.$*_.id"}, "a.b[count.index].id", 1},
+		{[]string{"-x", "a.$*_.id"}, "a.b[*].id", 1},
+		{[]string{"-x", "a.$*_.id"}, "a.b.*.id", 1},
+		{[]string{"-x", "a.$*_[$_].id"}, "a.b[count.index].id", 1},
+		{[]string{"-x", "a.$*_[$_].id"}, "a.b.c.id", 1},
+		{[]string{"-x", "a.$*_[*].id"}, "a.b[*].id", 1},
+		{[]string{"-x", "a.$*_[*].id"}, "a.b[0].id", 0},
+		{[]string{"-x", "x = $_.$*_"}, "x = a.b[count.index]", 1},
+		{[]string{"-x", "[a.$*x, b.$*x]"}, "[a.b[0], b.b[0]]", 1},
+		{[]string{"-x", "[a.$*x, b.$*x]"}, "[a.b[0], b.b[1]]", 0},
+		// the anonymous symbol of a splat is not a root
+		{[]string{"-x", "$*_.id"}, "a[*].id", 1},
+		{[]string{"-x", "$*_.id"}, "a[*].b[*].id", 1},
 
 		// relative traversal expression
 		{[]string{"-x", "sort()[0]"}, "sort()[0]", 1},
@@ -804,8 +847,89 @@ blk {
 			want: 1,
 		},
 
+		// deep wildcard
+		{[]string{"-x", "x = $**(count.index)"}, "x = count.index", 1},
+		{[]string{"-x", "x = $**(count.index)"}, "x = \"a-${count.index + 1}\"", 1},
+		{[]string{"-x", "x = $**(count.index)"}, "x = [{a = f(count.index)}]", 1},
+		{[]string{"-x", "x = $**(count.index)"}, "x = count.value", 0},
+		{[]string{"-x", "[$x, $**($x)]"}, "[a, [b, a]]", 1},
+		{[]string{"-x", "[$x, $**($x)]"}, "[a, [b, c]]", 0},
+		{
+			args: []string{"-x", `
+blk {
+  a = 1
+  @**{ b = $_ }
+}`},
+			src: `
+blk {
+  a = 1
+  nest {
+    nest {
+      b = 2
+    }
+  }
+}
+
+blk {
+  a = 1
+  b = 2
+}
+
+blk {
+  a = 1
+  c = 2
+}
+`,
+			want: 2,
+		},
+
+		// unordered body
+		{[]string{"-x", "blk {\na = 1\nb = 2\n}"}, "blk {\nb = 2\na = 1\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\na = 1\nb = 2\n}"}, "blk {\nb = 2\na = 1\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\na = 1\nb = 2\n}"}, "blk {\nb = 2\na = 1\nc = 3\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\na = 1\n@*_\n}"}, "blk {\nb = 2\na = 1\nc = 3\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\n@x\na = 1\n}"}, "blk {\na = 1\nb = 2\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\na = $v\nb = $v\n}"}, "blk {\nb = 1\na = 1\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\na = $v\nb = $v\n}"}, "blk {\nb = 1\na = 2\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\nnest {}\na = 1\n}"}, "blk {\na = 1\nnest {}\n}", 1},
+		{[]string{"-x", "blk {\n@~\na = 1\nb = 2\n}"}, "blk {\nb = 2\na = 1\n}", 1},
+		{[]string{"-x", "blk {\n@~\na = 1\nb = 2\n}"}, "blk {\nb = 2\nc = 1\n}", 0},
+		{[]string{"-x", "blk {\n@~\nb = 2\n@*_\n}"}, "blk {\na = 1\nb = 2\nc = 3\n}", 1},
+		{[]string{"-x", "blk {\nnest {\n@~\na = 1\nb = 2\n}\nc = 3\n}"}, "blk {\nc = 3\nnest {\nb = 2\na = 1\n}\n}", 0},
+		{[]string{"-x", "blk {\nnest {\n@~\na = 1\nb = 2\n}\nc = 3\n}"}, "blk {\nnest {\nb = 2\na = 1\n}\nc = 3\n}", 1},
+
+		// typed wildcard
+		{[]string{"-x", "count = $n:number"}, "count = 2", 1},
+		{[]string{"-x", "count = $n:number"}, "count = -2", 1},
+		{[]string{"-x", "count = $n:number"}, "count = length(var.x)", 0},
+		{[]string{"-x", "x = $v:string"}, `x = "a"`, 1},
+		{[]string{"-x", "x = $v:string"}, `x = "a${b}"`, 0},
+		{[]string{"-x", "x = $v:template"}, `x = "a${b}"`, 1},
+		{[]string{"-x", "x = $v:template"}, `x = "${b}"`, 1},
+		{[]string{"-x", "x = $v:bool"}, `x = false`, 1},
+		{[]string{"-x", "x = $v:bool"}, `x = "false"`, 0},
+		{[]string{"-x", "x = $v:traversal"}, `x = a.b[c].d`, 1},
+		{[]string{"-x", "x = $v:traversal"}, `x = f().b`, 0},
+		{[]string{"-x", "x = $v:call"}, `x = f()`, 1},
+		{[]string{"-x", "x = $v:tuple"}, `x = [1]`, 1},
+		{[]string{"-x", "x = $v:object"}, `x = {}`, 1},
+		{[]string{"-x", "x = $v:object"}, `x = []`, 0},
+		{[]string{"-x", "[$v:number, $v]"}, `[1, 1]`, 1},
+		{[]string{"-x", "a[$i:number]"}, `a[0]`, 1},
+		{[]string{"-x", "a[$i:number]"}, `a["k"]`, 0},
+		{[]string{"-x", "a[$i:string]"}, `a.b`, 0},
+		{[]string{"-x", "$c ? $a:$b"}, `x ? y : z`, 1},
+		{[]string{"-x", "$c ? $a : string"}, `x ? y : string`, 1},
+		{[]string{"-x", "[for $k in $c: $k]"}, `[for k in c: k]`, 1},
+
 		// expr tokenize errors
 		{[]string{"-x", "$"}, "", tokErr(":1,2-2: wildcard must be followed by ident, got TokenEOF")},
+		{[]string{"-x", "$*x:string"}, "", tokErr(`:1,5-11: only the expression wildcard (excluding the any wildcard) can have a type, got "string"`)},
+		{[]string{"-x", "$v:foo"}, "", tokErr(`:1,4-7: unknown wildcard type "foo" (valid: bool, call, number, object, string, template, traversal, tuple)`)},
+		{[]string{"-x", "$foo-string"}, "", tokErr(`:1,2-12: wildcard name can't contain "-", got "foo-string"`)},
+		{[]string{"-x", "@a-1"}, "", tokErr(`:1,2-5: wildcard name can't contain "-", got "a-1"`)},
+		{[]string{"-x", "$**"}, "", tokErr(":1,4-4: deep wildcard must be followed by \"(\", got TokenEOF")},
+		{[]string{"-x", "@**(a)"}, "", tokErr(":1,4-5: deep wildcard must be followed by \"{\", got TokenOParen")},
 
 		// expr parse errors
 		{[]string{"-x", "a = "}, "", parseErr(":1,3-3: Missing expression; Expected the start of an expression, but found the end of the file.")},
@@ -927,6 +1051,41 @@ blk {
 			src:  ``,
 			want: attrErr(":1,9-13: invalid content after attribute value"),
 		},
+		{
+			args: []string{"-x", "{@*_, @a}", "-rx", `a="bar = .*"`},
+			src:  `x = {foo = 1, bar = 2}`,
+			want: `{foo = 1, bar = 2}`,
+		},
+		{
+			args: []string{"-x", "{@a, @*_}", "-rx", `a.key="bar"`},
+			src:  `x = {foo = 1, bar = 2}`,
+			want: 0,
+		},
+		{
+			args: []string{"-x", "{@a, @*_}", "-rx", `a.key="foo"`},
+			src:  `x = {"foo" = 1, bar = 2}`,
+			want: `{"foo" = 1, bar = 2}`,
+		},
+		{
+			args: []string{"-x", "{@a, @*_}", "-rx", `a.value="1"`},
+			src:  `x = {foo = 1, bar = 2}`,
+			want: `{foo = 1, bar = 2}`,
+		},
+		{
+			args: []string{"-x", "@a", "-rx", `a.key="x"`},
+			src:  `x = 1`,
+			want: `x = 1`,
+		},
+		{
+			args: []string{"-x", "x = $a", "-rx", `a.key="x"`},
+			src:  `x = 1`,
+			want: 0,
+		},
+		{
+			args: []string{"-x", "x = $a", "-rx", `a.name="x"`},
+			src:  ``,
+			want: attrErr(`unknown selector "name", must be "key" or "value"`),
+		},
 
 		// "-v"
 		{
@@ -1009,6 +1168,7 @@ blk {
 	for i, tc := range tests {
 		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
 			matchTest(t, tc.args, tc.src, tc.want)
+			roundTripTest(t, tc.src)
 		})
 	}
 }
@@ -1062,6 +1222,7 @@ func matchStrs(m Matcher, src string) []hclsyntax.Node {
 	if err != nil {
 		panic(fmt.Sprintf("parsing source node: %v", err))
 	}
+	m.b = []byte(src)
 	return m.matches(srcNode)
 }
 
@@ -1086,9 +1247,168 @@ foo = bar
 		// -w only prints nothing
 		{[]string{"-w", "abc"}, "foo = bar", ""},
 		// -w is not the last command
-		{[]string{"-x", "foo = $a", "-w", "a", "-x", "foo = $a"}, "foo = bar", otherErr("`-w` must be the last command")},
+		{[]string{"-x", "foo = $a", "-w", "a", "-x", "$a"}, "foo = bar", "bar\nbar\n"},
+		// -w with multiple names
+		{[]string{"-x", "blk $t $n {\n@*_\nx = $x\n}", "-w", "t,n,x"}, "blk a b {\n  x = 1\n}\nblk c d {\n  y = 1\n  x = [2]\n}\n", "a\tb\t1\nc\td\t[2]\n"},
+		{[]string{"-x", "foo = $a", "-w", "b, a"}, "foo = bar", "\tbar\n"},
+		{[]string{"-x", "foo = $a", "-w", "b"}, "foo = bar", ""},
+		// -w with an object item
+		{[]string{"-x", "{@*_, @a}", "-w", "a"}, "x = {\n  foo = 1\n  \"bar\" = [2]\n}\n", "\"bar\" = [2]\n"},
+		{[]string{"-x", "foo = $a", "-w", ","}, "foo = bar", otherErr("`-w` needs at least one wildcard name")},
+		// -w in the middle of the commands
+		{[]string{"-x", "blk $n {@*_}", "-w", "n", "-x", "x = $x", "-w", "n,x"}, "blk a {\n  x = 1\n}\nblk b {\n  y = 1\n}\n", "a\nb\na\t1\n"},
+		{[]string{"-x", "blk $n {@*_}", "-w", "n", "-g", "x = $_"}, "blk a {\n  x = 1\n}\nblk b {\n  y = 1\n}\n", "a\nb\nblk a {\n  x = 1\n}\n"},
 		// -w
 		{[]string{"-x", "foo = $a", "-w", "a"}, "foo = bar", "bar\n"},
+		// -json
+		{[]string{"-json", "-x", "foo = $a"}, "foo = bar", `{"filename":"","start":{"line":1,"column":1,"byte":0},"end":{"line":1,"column":10,"byte":9},"text":"foo = bar","type":"Attribute","captures":{"a":{"kind":"node","text":"bar","type":"ScopeTraversalExpr","range":{"start":{"line":1,"column":7,"byte":6},"end":{"line":1,"column":10,"byte":9}}}}}
+`},
+		{[]string{"-json", "-x", "blk $a {}"}, "blk foo {}", `{"filename":"","start":{"line":1,"column":1,"byte":0},"end":{"line":1,"column":11,"byte":10},"text":"blk foo {}","type":"Block","captures":{"a":{"kind":"string","text":"foo","range":{"start":{"line":1,"column":5,"byte":4},"end":{"line":1,"column":8,"byte":7}}}}}
+`},
+		{[]string{"-json", "-x", "foo = a.$a"}, "foo = a.b", `{"filename":"","start":{"line":1,"column":1,"byte":0},"end":{"line":1,"column":10,"byte":9},"text":"foo = a.b","type":"Attribute","captures":{"a":{"kind":"string","text":"b","range":{"start":{"line":1,"column":9,"byte":8},"end":{"line":1,"column":10,"byte":9}}}}}
+`},
+		// -s
+		{[]string{"-x", "foo = $a", "-s", "foo = [$a]"}, "foo = bar\n\n# comment\nbaz = 1\n", "foo = [bar]\n\n# comment\nbaz = 1\n"},
+		{[]string{"-x", "var.$v[count.index]", "-s", "var.$v[0]"}, "a = var.x[count.index]\nb = var.y[count.index]\n", "a = var.x[0]\nb = var.y[0]\n"},
+		{[]string{"-x", "a[$i]", "-s", "b[$i]"}, "x = a[0]\n", "x = b[0]\n"},
+		{[]string{"-x", "blk $l {@*_}", "-s", `blk $l {}`}, "blk \"foo\" {\n  a = 1\n}\n", "blk \"foo\" {}\n"},
+		{[]string{"-x", "resource $t $n {@*_}", "-s", `resource $t $n {}`}, "resource \"aws_s3_bucket\" \"my.bucket\" {\n  a = 1\n}\n", "resource \"aws_s3_bucket\" \"my.bucket\" {}\n"},
+		{[]string{"-x", "blk $*l {@*_}", "-s", `new $l {}`}, "blk a \"b.c\" {}\n", "new a \"b.c\" {}\n"},
+		// -s that makes the file invalid
+		{[]string{"-x", "foo = $a", "-s", "$a"}, "foo = bar\n", otherErr(`the rewritten file is invalid: :1,1-4: Argument or block definition required; An argument or block definition is required here. To set an argument, use the equals sign "=" to introduce the argument value.`)},
+		// -s only rewrites the outermost match
+		{[]string{"-x", "[$a]", "-s", "$a"}, "x = [[1]]\n", "x = [1]\n"},
+		// -s with unrecorded wildcard
+		{[]string{"-x", "foo = $_", "-s", "foo = $a"}, "foo = bar", otherErr(`wildcard "a" in the template is not recorded`)},
+		{[]string{"-x", "foo = $a", "-s", "foo = $_"}, "foo = bar", otherErr(`:1,7-9: wildcard "_" can't be used in template`)},
+		{[]string{"-x", "foo = $a", "-s", "foo = $**(a)"}, "foo = bar", otherErr(`:1,7-10: deep wildcard can't be used in template`)},
+		{[]string{"-x", "foo = $a", "-s", "foo = $a:string"}, "foo = bar", otherErr(`:1,7-16: wildcard type can't be used in template`)},
+		// typed wildcard
+		{[]string{"-x", "count = $n:number", "-w", "n"}, "a {\n  count = 2\n}\nb {\n  count = var.n\n}\n", "2\n"},
+		// unordered body
+		{[]string{"-x", "blk $n {\n@~\na = 1\n@*rest\n}", "-w", "n,rest"}, "blk x {\n  b = 2\n  a = 1\n  c = 3\n}\n", "x\tb = 2\nc = 3\n"},
+		{[]string{"-x", "blk $n {\n@~\n@*rest\n}", "-w", "rest"}, "blk x {\n  b = 2\n  a = 1\n}\n", "b = 2\n  a = 1\n"},
+		{[]string{"-x", "blk {\n@~\n}", "-s", "blk {\n@~\n}"}, "", otherErr(`:2,1-3: unordered marker can't be used in template`)},
+		// -s is not the last command
+		{[]string{"-x", "foo = $a", "-s", "$a", "-x", "foo = $a"}, "foo = bar", otherErr("`-s` must be the last command")},
+		// -diff
+		{[]string{"-diff", "-x", "foo = $a", "-s", "foo = [$a]"}, "foo = bar\n", `--- a/
++++ b/
+@@ -1 +1 @@
+-foo = bar
++foo = [bar]
+`},
+		{[]string{"-diff", "-x", "foo = $a"}, "foo = bar\n", otherErr("`-write` and `-diff` can only be used with a rewriting command")},
+		// -delete
+		{[]string{"-x", "skip = $_", "-delete"}, "blk {\n  a = 1\n  skip = true # deprecated\n  b = 2\n}\n", "blk {\n  a = 1\n  b = 2\n}\n"},
+		{[]string{"-x", "nested {@*_}", "-delete"}, "blk {\n  nested {\n    skip = true\n  }\n\n  nested {}\n  a = 1\n}\n", "blk {\n\n  a = 1\n}\n"},
+		{[]string{"-x", "skip = $_", "-delete"}, "blk { skip = 1 }\n", "blk {  }\n"},
+		{[]string{"-x", "{@*_, @i, @*_}", "-rx", `i.key="a"`, "-delete"}, "x = {\n  a = 1\n  b = 2\n}\n", "x = {\n  b = 2\n}\n"},
+		{[]string{"-x", "{@*_, @i, @*_}", "-rx", `i.key="a"`, "-delete"}, "x = { a = 1, b = 2 }\n", "x = { b = 2 }\n"},
+		{[]string{"-x", "{@*_, @i}", "-rx", `i.key="b"`, "-delete"}, "x = { a = 1, b = 2 }\n", "x = { a = 1 }\n"},
+		{[]string{"-x", "{a = $_, @*rest}", "-delete"}, "x = { a = 1, b = 2, c = 3 }\n", "x = { a = 1 }\n"},
+		{[]string{"-x", "{a = $_, @*rest}", "-delete"}, "x = {\n  a = 1\n  b = 2 # b\n  c = 3\n}\n", "x = {\n  a = 1\n}\n"},
+		// the variables of the same name as a key are not deleted
+		{[]string{"-x", "{@*_, @i, @*_}", "-rx", `i.key="a"`, "-delete"}, "x = { a = 1, b = a }\ny = a\n", "x = { b = a }\ny = a\n"},
+		{[]string{"-x", "{@*_}", "-delete"}, "x = { a = 1 }\n", otherErr(":1,5-14: can't delete ObjectConsExpr, only attributes, blocks and the recorded items of objects can be deleted")},
+		{[]string{"-x", "1", "-delete"}, "x = 1\n", otherErr(":1,5-6: can't delete LiteralValueExpr, only attributes, blocks and the recorded items of objects can be deleted")},
+		{[]string{"-x", "x = 1", "-delete", "-x", "x = 1"}, "", otherErr("`-delete` must be the last command")},
+		// -insert-body and -append
+		{[]string{"-x", "blk $n {@*_}", "-insert-body", "name = $n"}, "blk a {\n  # comment\n  x = 1\n}\n", "blk a {\n  name = a\n  # comment\n  x = 1\n}\n"},
+		{[]string{"-x", "blk $n {@*_}", "-append", "lifecycle {\nprevent_destroy = true\n}"}, "blk a {\n  x = 1\n}\n", "blk a {\n  x = 1\n  lifecycle {\n    prevent_destroy = true\n  }\n}\n"},
+		{[]string{"-x", "blk {@*_}", "-append", "b = 2"}, "blk {}\n", "blk {\n  b = 2\n}\n"},
+		{[]string{"-x", "blk {@*_}", "-insert-body", "b = 2"}, "blk { a = 1 }\n", "blk {\n  b = 2\n  a = 1\n}\n"},
+		// the existing attributes and equal blocks are skipped
+		{[]string{"-x", "blk {@*_}", "-append", "a = 2\nb = 2\nnest {\nc = 1\n}"}, "blk {\n  a = 1\n  nest {\n    c = 1\n  }\n}\n", "blk {\n  a = 1\n  nest {\n    c = 1\n  }\n  b = 2\n}\n"},
+		{[]string{"-x", "blk {@*_}", "-append", "a = 2"}, "blk {\n  a   =   1\n}\n", "blk {\n  a   =   1\n}\n"},
+		// the block of the same type and labels is merged into
+		{[]string{"-x", "blk $n {@*_}", "-append", "lifecycle { prevent_destroy = true }"}, "blk a {\n  lifecycle {}\n}\n", "blk a {\n  lifecycle {\n    prevent_destroy = true\n  }\n}\n"},
+		{[]string{"-x", "blk $n {@*_}", "-append", "lifecycle { prevent_destroy = true }"}, "blk a {\n  lifecycle {\n    create_before_destroy = true\n  }\n}\n", "blk a {\n  lifecycle {\n    create_before_destroy = true\n    prevent_destroy       = true\n  }\n}\n"},
+		{[]string{"-x", "blk $n {@*_}", "-append", "lifecycle { prevent_destroy = true }"}, "blk a {\n  lifecycle {\n    prevent_destroy = false\n  }\n}\n", "blk a {\n  lifecycle {\n    prevent_destroy = false\n  }\n}\n"},
+		{[]string{"-x", "blk {@*_}", "-append", "p \"b\" {}"}, "blk {\n  p \"a\" {}\n}\n", "blk {\n  p \"a\" {}\n  p \"b\" {}\n}\n"},
+		{[]string{"-x", "a = $_", "-append", "b = 2"}, "a = 1", otherErr(":1,1-6: can't insert into Attribute, only blocks can be inserted into")},
+		{[]string{"-x", "blk {@*_}", "-append", "b = "}, "blk {}", otherErr("cannot parse snippet: :1,5-5: Missing expression; Expected the start of an expression, but found the end of the file.")},
+		// -n and -vimgrep
+		{[]string{"-n", "-x", "blk {@*_}"}, "a = 1\nblk {\n  b = 2\n}\n", ":2:1:blk {\n  b = 2\n}\n"},
+		{[]string{"-n", "-x", "2"}, "a = 1\nblk {\n  b = 2\n}\n", ":3:7:2\n"},
+		// the matches are output in the order of their positions
+		{[]string{"-n", "-x", "$_ = 1"}, "a = 1\nb = 1\nc = 1\nd = 1\ne = 1\n", ":1:1:a = 1\n:2:1:b = 1\n:3:1:c = 1\n:4:1:d = 1\n:5:1:e = 1\n"},
+		{[]string{"-x", "$k = 1", "-w", "k"}, "a = 1\nb = 1\nc = 1\nd = 1\ne = 1\n", "a\nb\nc\nd\ne\n"},
+		{[]string{"-vimgrep", "-x", "blk {@*_}"}, "a = 1\nblk {\n  b = 2\n}\n", ":2:1:blk {\n:3:1:  b = 2\n:4:1:}\n"},
+		{[]string{"-vimgrep", "-x", "b"}, "a = 1\nx = { a = \"é\", y = b }\n", ":2:21:x = { a = \"é\", y = b }\n"},
+		{[]string{"-n", "-vimgrep", "-x", "a"}, "", otherErr("`-n` and `-vimgrep` can't be used together")},
+		{[]string{"-n", "-json", "-x", "a"}, "", otherErr("`-n` can't be used with `-json`")},
+		// -format
+		{[]string{"-format", "{{.File}}:{{.Line}}:{{.Column}} {{.Type}} {{.Captures.s}} {{.Captures.v}}", "-x", "module $_ {\nsource = $s\nversion = $v\n}"}, "a = 1\nmodule \"a\" {\n  source = \"x\"\n  version = 1\n}\n", ":2:1 Block \"x\" 1\n"},
+		{[]string{"-format", "{{.Text}} {{.Start.Byte}}-{{.End.Byte}}[{{.Captures.nope}}]", "-x", "a = $_"}, "a = 1\n", "a = 1 0-5[]\n"},
+		{[]string{"-format", "{{.Nope}}", "-x", "a = $_"}, "a = 1\n", otherErr(`template: format:1:2: executing "format" at <.Nope>: can't evaluate field Nope in type hclgrep.formatData`)},
+		{[]string{"-format", "{{", "-x", "a"}, "", otherErr("cannot parse format: template: format:1: unclosed action")},
+		{[]string{"-format", "{{.Text}}", "-json", "-x", "a"}, "", otherErr("`-format` can't be used with `-json`")},
+		{[]string{"-format", "{{.Text}}", "-vimgrep", "-x", "a"}, "", otherErr("`-format` can't be used with `-vimgrep`")},
+		{[]string{"-format", "{{.Text}}", "-H", "-x", "a"}, "", otherErr("`-format` can't be used with `-H`")},
+		{[]string{"-format", "{{.Text}}", "-n", "-x", "a"}, "", otherErr("`-format` can't be used with `-n`")},
+		// -color
+		{[]string{"-color=always", "-x", "foo($x, $y)"}, "a = foo(b, c)\n", "\x1b[1;31mfoo(\x1b[0m\x1b[1;32mb\x1b[0m\x1b[1;31m, \x1b[0m\x1b[1;33mc\x1b[0m\x1b[1;31m)\x1b[0m\n"},
+		// the captured block types, labels and names are colored
+		{[]string{"-color=always", "-x", "$t $l {}"}, "blk \"a\" {}\n", "\x1b[1;33mblk\x1b[0m\x1b[1;31m \x1b[0m\x1b[1;32m\"a\"\x1b[0m\x1b[1;31m {}\x1b[0m\n"},
+		{[]string{"-color=always", "-x", "$n = var.$v"}, "a = var.b\n", "\x1b[1;32ma\x1b[0m\x1b[1;31m = var.\x1b[0m\x1b[1;33mb\x1b[0m\n"},
+		{[]string{"-color=always", "-n", "-x", "b"}, "a = b\n", "\x1b[35m:1:5\x1b[0m:\x1b[1;31mb\x1b[0m\n"},
+		{[]string{"-color=always", "-C", "1", "-x", "b"}, "a = b\nc = 1\nd = 1\ne = 1\nf = b\n", "a = \x1b[1;31mb\x1b[0m\nc = 1\n\x1b[36m--\x1b[0m\ne = 1\nf = \x1b[1;31mb\x1b[0m\n"},
+		{[]string{"-color=never", "-x", "b"}, "a = b\n", "b\n"},
+		{[]string{"-color=bad", "-x", "b"}, "", otherErr("the value of `-color` must be one of auto, always and never, got \"bad\"")},
+		// -heading
+		{[]string{"-heading", "-x", "source = $_"}, "module \"x\" {\n  source = \"a\"\n}\nresource \"t\" \"n\" {\n  nic {\n    source = \"b\"\n  }\n}\n", "module.x\nsource = \"a\"\nresource.t.n > nic\nsource = \"b\"\n"},
+		{[]string{"-heading", "-x", "a = $_"}, "a = 1\n", "a = 1\n"},
+		{[]string{"-heading", "-json", "-x", "a = $_"}, "blk {\n  a = 1\n}\n", `{"filename":"","start":{"line":2,"column":3,"byte":8},"end":{"line":2,"column":8,"byte":13},"text":"a = 1","type":"Attribute","captures":{},"heading":"blk"}
+`},
+		{[]string{"-heading", "-C", "1", "-x", "a"}, "", otherErr("`-A`, `-B` and `-C` can't be used with `-heading`")},
+		// -A, -B and -C
+		{[]string{"-C", "1", "-x", "b = 2"}, "a = 1\nblk {\n  b = 2\n}\nc = 1\nd = 1\nb = 2\n", "blk {\n  b = 2\n}\n--\nd = 1\nb = 2\n"},
+		{[]string{"-A", "1", "-x", "b = $_"}, "b = 1\nblk {\n  b = 2\n}", "b = 1\nblk {\n  b = 2\n}\n"},
+		{[]string{"-B", "2", "-n", "-x", "blk {@*_}"}, "a = 1\nb = 1\nc = 1\nblk {\n}\n", "-2-b = 1\n-3-c = 1\n:4:blk {\n:5:}\n"},
+		{[]string{"-C", "1", "-B", "0", "-H", "-x", "a = 1"}, "a = 1\nb = 1\n", ":a = 1\n-b = 1\n"},
+		{[]string{"-C", "-1", "-x", "a"}, "", otherErr("the numbers follow `-A`, `-B` and `-C` must >=0")},
+		{[]string{"-C", "1", "-json", "-x", "a"}, "", otherErr("`-A`, `-B` and `-C` can't be used with `-json`")},
+		// -c, -l and -L
+		{[]string{"-c", "-x", "a = $_"}, "a = 1\nb = 1\nblk {\n  a = 2\n}\n", ":2\n"},
+		{[]string{"-l", "-x", "a = $_"}, "b = 1\n", ""},
+		{[]string{"-L", "-x", "a = $_"}, "b = 1\n", "\n"},
+		{[]string{"-c", "-l", "-x", "a"}, "", otherErr("`-c` and `-l` can't be used together")},
+		{[]string{"-c", "-json", "-x", "a"}, "", otherErr("`-c` can't be used with `-json`")},
+		{[]string{"-l", "-x", "a = $a", "-w", "a"}, "", otherErr("`-l` can't be used with `-w`")},
+		{[]string{"-c", "-x", "a = $a", "-w", "a", "-x", "$a"}, "", otherErr("`-c` can't be used with `-w`")},
+		{[]string{"-l", "-x", "a = $a", "-w", "a", "-x", "$a"}, "", otherErr("`-l` can't be used with `-w`")},
+		{[]string{"-L", "-x", "a = $a", "-w", "a", "-x", "$a"}, "", otherErr("`-L` can't be used with `-w`")},
+		{[]string{"-c", "-x", "a = $a", "-s", "a = 1"}, "", otherErr("`-c` can't be used with `-s`")},
+		// -w in the middle can't be used with the rewriting commands
+		{[]string{"-x", "a = $a", "-w", "a", "-s", "a = 1"}, "", otherErr("`-w` can't be used with `-s`")},
+		{[]string{"-x", "a = $a", "-w", "a", "-delete"}, "", otherErr("`-w` can't be used with `-delete`")},
+		{[]string{"-x", "blk {@*_}", "-w", "a", "-insert-body", "a = 1"}, "", otherErr("`-w` can't be used with `-insert-body`")},
+		{[]string{"-x", "blk {@*_}", "-w", "a", "-append", "a = 1"}, "", otherErr("`-w` can't be used with `-append`")},
+		// the output flags of the matches are rejected with the rewriting commands and a terminal -w
+		{[]string{"-json", "-x", "a = $_", "-s", "a = 5"}, "", otherErr("`-json` can't be used with `-s`")},
+		{[]string{"-n", "-x", "a = $_", "-s", "a = 5"}, "", otherErr("`-n` can't be used with `-s`")},
+		{[]string{"-C", "2", "-x", "a = $_", "-delete"}, "", otherErr("`-A`, `-B` and `-C` can't be used with `-delete`")},
+		{[]string{"-heading", "-x", "blk {@*_}", "-append", "a = 1"}, "", otherErr("`-heading` can't be used with `-append`")},
+		{[]string{"-format", "{{.Text}}", "-x", "a = $v", "-w", "v"}, "", otherErr("`-format` can't be used with `-w`")},
+		{[]string{"-H", "-x", "a = $v", "-w", "v"}, "", otherErr("`-H` can't be used with `-w`")},
+		{[]string{"-heading", "-x", "a = $v", "-w", "v", "-x", "$v"}, "a = 1\n", "1\n1\n"},
+		// -json with -w
+		{[]string{"-json", "-x", "foo = $a", "-w", "a"}, "foo = bar", `{"filename":"","name":"a","kind":"node","text":"bar","type":"ScopeTraversalExpr","range":{"start":{"line":1,"column":7,"byte":6},"end":{"line":1,"column":10,"byte":9}}}
+`},
+		// any wildcards
+		{[]string{"-x", "module.$*m.id", "-w", "m"}, "a = module.foo[count.index].id", "foo[count.index]\n"},
+		{[]string{"-x", "data.$_.$*rest", "-w", "rest"}, "a = data.x.y[0].z", "y[0].z\n"},
+		{[]string{"-x", "module.$*m.id", "-s", "module.$m.name"}, "a = module.foo[0].id", "a = module.foo[0].name"},
+		{[]string{"-x", "f($_, $*rest)", "-w", "rest"}, "a = [f(1, 2, 3), f(1)]", "2, 3\n\n"},
+		{[]string{"-x", "blk $*labels {@*body}", "-w", "labels,body"}, "blk a \"b\" {\n  x = 1\n  y = 2\n}\n", "a b\tx = 1\n  y = 2\n"},
+		{[]string{"-x", "{@*items}", "-w", "items"}, "a = {x = 1, y = 2}", "x = 1, y = 2\n"},
+		{[]string{"-x", "f($*args)", "-rx", `args="3"`}, "a = f(1, 2, 3)\nb = f(1, 2)\nc = f()\n", "f(1, 2, 3)\n"},
+		{[]string{"-x", "f($*args)", "-format", "{{.Captures.args}}"}, "a = f(1, [2])", "1, [2]\n"},
+		{[]string{"-json", "-x", "f($*args)", "-w", "args"}, "a = f(1, 2)", `{"filename":"","name":"args","kind":"list","text":"1, 2","range":{"start":{"line":1,"column":7,"byte":6},"end":{"line":1,"column":11,"byte":10}},"items":[{"kind":"node","text":"1","type":"LiteralValueExpr","range":{"start":{"line":1,"column":7,"byte":6},"end":{"line":1,"column":8,"byte":7}}},{"kind":"node","text":"2","type":"LiteralValueExpr","range":{"start":{"line":1,"column":10,"byte":9},"end":{"line":1,"column":11,"byte":10}}}]}
+`},
+		{[]string{"-json", "-x", "f($*args)", "-w", "args"}, "a = f()", `{"filename":"","name":"args","kind":"list","text":""}
+`},
 	}
 
 	for i, tc := range tests {
@@ -1103,8 +1423,21 @@ func fileTest(t *testing.T, args []string, src string, anyWant interface{}) {
 		t.Fatalf("%v | %s: %s", args, src, fmt.Sprintf(format, a...))
 	}
 	opts, _, err := ParseArgs(args)
-	switch want := anyWant.(type) {
-	case wantErr:
+	if err != nil {
+		if want, ok := anyWant.(wantErr); ok {
+			if got := err.Error(); got != string(want) {
+				tfatalf("wanted error %q, got %q", want, got)
+			}
+			return
+		}
+		tfatalf("unexpected error: %v", err)
+	}
+
+	buf := bytes.NewBufferString("")
+	opts = append(opts, OptionOutput(buf))
+	m := NewMatcher(opts...)
+	err = m.File("", bytes.NewBufferString(src))
+	if want, ok := anyWant.(wantErr); ok {
 		if err == nil {
 			tfatalf("wanted error %q, got none", want)
 		} else if got := err.Error(); got != string(want) {
@@ -1113,13 +1446,6 @@ func fileTest(t *testing.T, args []string, src string, anyWant interface{}) {
 		return
 	}
 	if err != nil {
-		tfatalf("unexpected error: %v", err)
-	}
-
-	buf := bytes.NewBufferString("")
-	opts = append(opts, OptionOutput(buf))
-	m := NewMatcher(opts...)
-	if err := m.File("", bytes.NewBufferString(src)); err != nil {
 		tfatalf("m.file() error: %v", err)
 	}
 	switch want := anyWant.(type) {
@@ -1132,3 +1458,254 @@ func fileTest(t *testing.T, args []string, src string, anyWant interface{}) {
 		panic(fmt.Sprintf("unexpected anyWant type: %T", anyWant))
 	}
 }
+
+func TestFilesParallel(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("%02d.tf", i)] = fmt.Sprintf("blk {\n  a = %d\n}\n", i)
+	}
+	writeFiles(t, dir, files)
+
+	var want string
+	for i := 0; i < 20; i++ {
+		want += fmt.Sprintf("%d\n", i)
+	}
+	for _, jobs := range []int{1, 4, 32} {
+		opts, _, err := ParseArgs([]string{"-x", "blk {@*_}", "-x", "a = $a", "-w", "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.NewBufferString("")
+		opts = append(opts, OptionOutput(buf), OptionJobs(jobs))
+		m := NewMatcher(opts...)
+		if _, err := m.Files([]string{dir}); err != nil {
+			t.Fatalf("-j %d: unexpected error: %v", jobs, err)
+		}
+		if got := buf.String(); got != want {
+			t.Fatalf("-j %d: wanted:\n%s\ngot:\n%s\n", jobs, want, got)
+		}
+	}
+}
+
+func TestFilesSummary(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tf": "a = 1\nblk {\n  a = 2\n}\n",
+		"b.tf": "b = 1\n",
+		"c.tf": "a = 3\n",
+	})
+	a, b, c := filepath.Join(dir, "a.tf"), filepath.Join(dir, "b.tf"), filepath.Join(dir, "c.tf")
+
+	tests := []struct {
+		args  []string
+		files []string
+		want  string
+	}{
+		{[]string{"-c", "-x", "a = $_"}, []string{dir}, fmt.Sprintf("%s:2\n%s:0\n%s:1\ntotal:3\n", a, b, c)},
+		{[]string{"-c", "-x", "a = $_"}, []string{c}, fmt.Sprintf("%s:1\n", c)},
+		{[]string{"-l", "-x", "a = $_"}, []string{dir}, fmt.Sprintf("%s\n%s\n", a, c)},
+		{[]string{"-L", "-x", "a = $_"}, []string{dir}, fmt.Sprintf("%s\n", b)},
+		// the groups of context lines of different files are also separated
+		{[]string{"-B", "1", "-x", "a = $_"}, []string{dir}, "a = 1\nblk {\n  a = 2\n--\na = 3\n"},
+		{[]string{"-color=always", "-B", "1", "-x", "a = $_"}, []string{c, b, a}, "\x1b[1;31ma = 3\x1b[0m\n\x1b[36m--\x1b[0m\n\x1b[1;31ma = 1\x1b[0m\nblk {\n  \x1b[1;31ma = 2\x1b[0m\n"},
+	}
+	for _, tc := range tests {
+		for _, jobs := range []int{1, 4} {
+			opts, _, err := ParseArgs(tc.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := bytes.NewBufferString("")
+			opts = append(opts, OptionOutput(buf), OptionJobs(jobs))
+			m := NewMatcher(opts...)
+			if _, err := m.Files(tc.files); err != nil {
+				t.Fatalf("%v -j %d: unexpected error: %v", tc.args, jobs, err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Fatalf("%v -j %d: wanted:\n%s\ngot:\n%s\n", tc.args, jobs, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestFilesResult(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tf": "a = 1\n",
+		"b.tf": "b = 1\n",
+		"c.tf": "c = \n",
+		"d.tf": "a = 2\n",
+	})
+	for _, jobs := range []int{1, 4} {
+		// the file that can't be parsed is reported and skipped
+		opts, _, err := ParseArgs([]string{"-x", "a = $_"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		errBuf := bytes.NewBufferString("")
+		m := NewMatcher(append(opts, OptionOutput(io.Discard), OptionErrorOutput(errBuf), OptionJobs(jobs))...)
+		res, err := m.Files([]string{dir})
+		if err != nil {
+			t.Fatalf("-j %d: unexpected error: %v", jobs, err)
+		}
+		if want := (Result{Matches: 2, SelectedFiles: 2, Files: 4, FailedFiles: 1}); res != want {
+			t.Fatalf("-j %d: wanted result %+v, got %+v", jobs, want, res)
+		}
+		if got := errBuf.String(); !strings.Contains(got, "Error: Invalid expression") || !strings.Contains(got, "c.tf line 1") {
+			t.Fatalf("-j %d: unexpected diagnostics:\n%s", jobs, got)
+		}
+
+		// -strict stops at the file
+		opts, _, err = ParseArgs([]string{"-strict", "-x", "a = $_"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m = NewMatcher(append(opts, OptionOutput(io.Discard), OptionJobs(jobs))...)
+		res, err = m.Files([]string{dir})
+		if err == nil {
+			t.Fatalf("-j %d: wanted error, got none", jobs)
+		}
+		if want := (Result{Matches: 1, SelectedFiles: 1, Files: 3, FailedFiles: 1}); res != want {
+			t.Fatalf("-j %d: wanted result %+v, got %+v", jobs, want, res)
+		}
+
+		// -lenient searches the file, which is still counted
+		opts, _, err = ParseArgs([]string{"-lenient", "-x", "a = $_"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m = NewMatcher(append(opts, OptionOutput(io.Discard), OptionErrorOutput(io.Discard), OptionJobs(jobs))...)
+		res, err = m.Files([]string{dir})
+		if err != nil {
+			t.Fatalf("-j %d: unexpected error: %v", jobs, err)
+		}
+		if want := (Result{Matches: 2, SelectedFiles: 2, Files: 4, InvalidFiles: 1}); res != want {
+			t.Fatalf("-j %d: wanted result %+v, got %+v", jobs, want, res)
+		}
+	}
+}
+
+func TestFilesSelected(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tf": "a = 1\n",
+		"b.tf": "b = 1\n",
+	})
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-x", "a = $_"}, 1},
+		{[]string{"-x", "x = $_"}, 0},
+		{[]string{"-c", "-x", "a = $_"}, 1},
+		{[]string{"-l", "-x", "a = $_"}, 1},
+		{[]string{"-l", "-x", "x = $_"}, 0},
+		// the files printed by -L are the selected ones
+		{[]string{"-L", "-x", "a = $_"}, 1},
+		{[]string{"-L", "-x", "x = $_"}, 2},
+		{[]string{"-L", "-x", "$_ = 1"}, 0},
+		// the final matches of -w whose values are all missing are not selected
+		{[]string{"-x", "a = $v", "-w", "v"}, 1},
+		{[]string{"-x", "a = $v", "-w", "w"}, 0},
+		// the values printed by -w in the middle are selected
+		{[]string{"-x", "a = $v", "-w", "v", "-x", "x"}, 1},
+		{[]string{"-x", "$n = $v", "-w", "w", "-x", "$v"}, 2},
+	}
+	for _, tc := range tests {
+		opts, _, err := ParseArgs(tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewMatcher(append(opts, OptionOutput(io.Discard))...)
+		res, err := m.Files([]string{dir})
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.args, err)
+		}
+		if res.SelectedFiles != tc.want {
+			t.Fatalf("%v: wanted %d selected files, got %d", tc.args, tc.want, res.SelectedFiles)
+		}
+	}
+}
+
+func TestLenient(t *testing.T) {
+	src := "a = 1\nb = \nblk {\n  c = 2\n}\n"
+	tests := []struct {
+		args []string
+		want interface{}
+	}{
+		{[]string{"-lenient", "-x", "a = $_"}, "a = 1\n"},
+		{[]string{"-lenient", "-x", "blk {@*_}"}, "blk {\n  c = 2\n}\n"},
+		{[]string{"-lenient", "-x", "$_ = 1"}, "a = 1\n"},
+		{[]string{"-lenient", "-x", "b = $_"}, "(invalid) b =\n"},
+		{[]string{"-lenient", "-H", "-x", "b = $_"}, "stdin:2,1-4: (invalid)\nb =\n"},
+		{[]string{"-lenient", "-json", "-x", "b = $_"}, `{"filename":"stdin","start":{"line":2,"column":1,"byte":6},"end":{"line":2,"column":4,"byte":9},"text":"b =","type":"Attribute","captures":{},"invalid":true}
+`},
+		{[]string{"-x", "a = $_"}, wantErr("cannot parse source: stdin:2,5-3,1: Invalid expression; Expected the start of an expression, but found an invalid expression token.")},
+		// files with syntax errors are never rewritten
+		{[]string{"-lenient", "-x", "a = $_", "-delete"}, wantErr("cannot parse source: stdin:2,5-3,1: Invalid expression; Expected the start of an expression, but found an invalid expression token.")},
+	}
+	for _, tc := range tests {
+		opts, _, err := ParseArgs(tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, errBuf := bytes.NewBufferString(""), bytes.NewBufferString("")
+		m := NewMatcher(append(opts, OptionOutput(buf), OptionErrorOutput(errBuf))...)
+		err = m.File("stdin", bytes.NewBufferString(src))
+		if want, ok := tc.want.(wantErr); ok {
+			if err == nil || err.Error() != string(want) {
+				t.Fatalf("%v: wanted error %q, got %v", tc.args, want, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.args, err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Fatalf("%v: wanted:\n%s\ngot:\n%s", tc.args, tc.want, got)
+		}
+		// the diagnostics are still reported
+		if got := errBuf.String(); !strings.Contains(got, "Error: Invalid expression") {
+			t.Fatalf("%v: unexpected diagnostics:\n%s", tc.args, got)
+		}
+	}
+}
+
+func TestCompileExprConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node, err := compileExpr("{\n@a\n@a\n@*_\n}")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if l := len(node.(*hclsyntax.ObjectConsExpr).Items); l != 3 {
+				t.Errorf("wanted 3 items, got %d", l)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRelativeTo(t *testing.T) {
+	dir := filepath.FromSlash("/a/b")
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{filepath.FromSlash("/a/b/c/x.tf"), filepath.FromSlash("c/x.tf")},
+		{filepath.FromSlash("/a/bc/x.tf"), filepath.FromSlash("/a/bc/x.tf")},
+		{filepath.FromSlash("/a/x.tf"), filepath.FromSlash("/a/x.tf")},
+		{"x.tf", "x.tf"},
+		{"stdin", "stdin"},
+	}
+	for _, tc := range tests {
+		if got := relativeTo(dir, tc.fileName); got != tc.want {
+			t.Errorf("relativeTo(%q, %q): wanted %q, got %q", dir, tc.fileName, tc.want, got)
+		}
+	}
+}
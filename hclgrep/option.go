@@ -1,6 +1,9 @@
 package hclgrep
 
-import "io"
+import (
+	"io"
+	"text/template"
+)
 
 type Option func(*Matcher)
 
@@ -16,8 +19,140 @@ func OptionPrefixPosition(include bool) Option {
 	}
 }
 
+func OptionLineNumber(enable bool) Option {
+	return func(m *Matcher) {
+		m.lineNumber = enable
+	}
+}
+
+func OptionVimgrep(enable bool) Option {
+	return func(m *Matcher) {
+		m.vimgrep = enable
+	}
+}
+
 func OptionOutput(o io.Writer) Option {
 	return func(m *Matcher) {
 		m.out = o
 	}
 }
+
+func OptionErrorOutput(o io.Writer) Option {
+	return func(m *Matcher) {
+		m.errOut = o
+	}
+}
+
+func OptionJSON(enable bool) Option {
+	return func(m *Matcher) {
+		m.json = enable
+	}
+}
+
+func OptionExtensions(exts []string) Option {
+	return func(m *Matcher) {
+		m.extensions = exts
+	}
+}
+
+func OptionIncludes(patterns []string) Option {
+	return func(m *Matcher) {
+		m.includes = patterns
+	}
+}
+
+func OptionExcludes(patterns []string) Option {
+	return func(m *Matcher) {
+		m.excludes = patterns
+	}
+}
+
+func OptionHidden(include bool) Option {
+	return func(m *Matcher) {
+		m.hidden = include
+	}
+}
+
+func OptionNoIgnore(disable bool) Option {
+	return func(m *Matcher) {
+		m.noIgnore = disable
+	}
+}
+
+func OptionJobs(n int) Option {
+	return func(m *Matcher) {
+		m.jobs = n
+	}
+}
+
+func OptionWrite(enable bool) Option {
+	return func(m *Matcher) {
+		m.rewriteWrite = enable
+	}
+}
+
+func OptionDiff(enable bool) Option {
+	return func(m *Matcher) {
+		m.rewriteDiff = enable
+	}
+}
+
+func OptionCount(enable bool) Option {
+	return func(m *Matcher) {
+		m.count = enable
+	}
+}
+
+func OptionFilesWithMatches(enable bool) Option {
+	return func(m *Matcher) {
+		m.filesWithMatches = enable
+	}
+}
+
+func OptionFilesWithoutMatches(enable bool) Option {
+	return func(m *Matcher) {
+		m.filesWithoutMatches = enable
+	}
+}
+
+func OptionStrict(enable bool) Option {
+	return func(m *Matcher) {
+		m.strict = enable
+	}
+}
+
+func OptionLenient(enable bool) Option {
+	return func(m *Matcher) {
+		m.lenient = enable
+	}
+}
+
+func OptionUnordered(enable bool) Option {
+	return func(m *Matcher) {
+		m.unordered = enable
+	}
+}
+
+func OptionContext(before, after int) Option {
+	return func(m *Matcher) {
+		m.before, m.after = before, after
+	}
+}
+
+func OptionHeading(enable bool) Option {
+	return func(m *Matcher) {
+		m.withHeading = enable
+	}
+}
+
+func OptionColor(enable bool) Option {
+	return func(m *Matcher) {
+		m.color = enable
+	}
+}
+
+func OptionFormat(tmpl *template.Template) Option {
+	return func(m *Matcher) {
+		m.format = tmpl
+	}
+}
@@ -0,0 +1,387 @@
+package hclgrep
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// jsonPos is the JSON representation of a hcl.Pos.
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// jsonRange is the JSON representation of a hcl.Range, excluding the filename.
+type jsonRange struct {
+	Start jsonPos `json:"start"`
+	End   jsonPos `json:"end"`
+}
+
+func newJSONRange(rng hcl.Range) jsonRange {
+	return jsonRange{
+		Start: jsonPos{Line: rng.Start.Line, Column: rng.Start.Column, Byte: rng.Start.Byte},
+		End:   jsonPos{Line: rng.End.Line, Column: rng.End.Column, Byte: rng.End.Byte},
+	}
+}
+
+// jsonMatch is the JSON representation of a final match.
+type jsonMatch struct {
+	Filename string `json:"filename"`
+	jsonRange
+	Text     string                 `json:"text"`
+	Type     string                 `json:"type"`
+	Captures map[string]jsonCapture `json:"captures"`
+	// whether the match overlaps any syntax error, in lenient mode
+	Invalid bool `json:"invalid,omitempty"`
+	// the breadcrumb path of the ancestor blocks, with "-heading"
+	Heading string `json:"heading,omitempty"`
+}
+
+// jsonCapture is the JSON representation of a recorded wildcard value.
+type jsonCapture struct {
+	Kind  string     `json:"kind"`
+	Text  string     `json:"text"`
+	Type  string     `json:"type,omitempty"`
+	Range *jsonRange `json:"range,omitempty"`
+	// the elements recorded by an any wildcard
+	Items []jsonCapture `json:"items,omitempty"`
+}
+
+// jsonWrite is the JSON representation of a wildcard value printed by the "-w" command.
+type jsonWrite struct {
+	Filename string `json:"filename"`
+	Name     string `json:"name"`
+	jsonCapture
+}
+
+// Kinds of the recorded wildcard values.
+const (
+	captureKindString         = "string"
+	captureKindNode           = "node"
+	captureKindObjectConsItem = "object_item"
+	captureKindTraverser      = "traverser"
+	captureKindList           = "list"
+)
+
+func (m *Matcher) jsonMatch(sub submatch) jsonMatch {
+	rng := sub.node.Range()
+	captures := make(map[string]jsonCapture, len(sub.values))
+	for name, val := range sub.values {
+		captures[name] = m.jsonCapture(val)
+	}
+	match := jsonMatch{
+		Filename:  relativeFileName(m.fileName),
+		jsonRange: newJSONRange(rng),
+		Text:      string(rng.SliceBytes(m.b)),
+		Type:      nodeTypeName(sub.node),
+		Captures:  captures,
+		Invalid:   m.invalid(sub.node),
+	}
+	if m.withHeading {
+		match.Heading = m.heading(sub.node)
+	}
+	return match
+}
+
+func (m *Matcher) jsonWrite(name string, val substitution) jsonWrite {
+	return jsonWrite{
+		Filename:    relativeFileName(m.fileName),
+		Name:        name,
+		jsonCapture: m.jsonCapture(val),
+	}
+}
+
+func (m *Matcher) jsonCapture(val substitution) jsonCapture {
+	var capture jsonCapture
+	switch {
+	case val.String != nil:
+		capture = jsonCapture{Kind: captureKindString}
+	case val.Node != nil:
+		capture = jsonCapture{Kind: captureKindNode, Type: nodeTypeName(val.Node)}
+	case val.ObjectConsItem != nil:
+		capture = jsonCapture{Kind: captureKindObjectConsItem}
+	case val.Traverser != nil:
+		capture = jsonCapture{Kind: captureKindTraverser}
+	case val.List != nil:
+		capture = jsonCapture{Kind: captureKindList}
+		for _, elem := range val.List {
+			capture.Items = append(capture.Items, m.jsonCapture(elem))
+		}
+	default:
+		panic("never reach here")
+	}
+	capture.Text = m.substitutionText(val)
+	if hasSubstitutionRange(val) {
+		jrng := newJSONRange(substitutionRange(val))
+		capture.Range = &jrng
+	}
+	return capture
+}
+
+// formatData is the data of a final match, rendered by the "-format" template.
+type formatData struct {
+	File string
+	// the 1-based line and column of the start of the match
+	Line, Column int
+	// the range of the match
+	Start, End hcl.Pos
+	Text       string
+	Type       string
+	// the source text of the captured wildcard values, by name
+	Captures map[string]string
+}
+
+func (m *Matcher) formatData(sub submatch) formatData {
+	rng := sub.node.Range()
+	captures := make(map[string]string, len(sub.values))
+	for name, val := range sub.values {
+		captures[name] = m.substitutionText(val)
+	}
+	return formatData{
+		File:     relativeFileName(m.fileName),
+		Line:     rng.Start.Line,
+		Column:   rng.Start.Column,
+		Start:    rng.Start,
+		End:      rng.End,
+		Text:     string(rng.SliceBytes(m.b)),
+		Type:     nodeTypeName(sub.node),
+		Captures: captures,
+	}
+}
+
+// writeMatch writes a final match to the matcher's out.
+func (m *Matcher) writeMatch(sub submatch) error {
+	if m.json {
+		return m.writeJSON(m.jsonMatch(sub))
+	}
+	if m.format != nil {
+		var buf bytes.Buffer
+		if err := m.format.Execute(&buf, m.formatData(sub)); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err := buf.WriteTo(m.out)
+		return err
+	}
+	rng := sub.node.Range()
+	fileName := relativeFileName(rng.Filename)
+	if m.vimgrep {
+		return m.writeVimgrep(fileName, sub)
+	}
+	if m.withHeading {
+		if heading := m.heading(sub.node); heading != "" {
+			if _, err := fmt.Fprintln(m.out, heading); err != nil {
+				return err
+			}
+		}
+	}
+	var output string
+	text := m.highlight(rng.Start.Byte, rng.End.Byte, sub)
+	switch {
+	case m.lineNumber:
+		if m.invalid(sub.node) {
+			text = fmt.Sprintf("%s %s", invalidMarker, text)
+		}
+		output = fmt.Sprintf("%s:%s", m.paint(colorPrefix, fmt.Sprintf("%s:%d:%d", fileName, rng.Start.Line, m.column(rng.Start))), text)
+	case m.prefix:
+		rng.Filename = fileName
+		header := m.paint(colorPrefix, rng.String()) + ":"
+		if m.invalid(sub.node) {
+			header += " " + invalidMarker
+		}
+		output = fmt.Sprintf("%s\n%s", header, text)
+	case m.invalid(sub.node):
+		output = fmt.Sprintf("%s %s", invalidMarker, text)
+	default:
+		output = text
+	}
+	_, err := fmt.Fprintf(m.out, "%s\n", output)
+	return err
+}
+
+// writeVimgrep writes each source line of the match, prefixed with the filename, line and column. The column is the
+// start of the match for the first line, and 1 for the others.
+func (m *Matcher) writeVimgrep(fileName string, sub submatch) error {
+	rng := sub.node.Range()
+	marker := ""
+	if m.invalid(sub.node) {
+		marker = invalidMarker + " "
+	}
+	offset, line, col := rng.Start.Byte, rng.Start.Line, m.column(rng.Start)
+	for {
+		start := bytes.LastIndexByte(m.b[:offset], '\n') + 1
+		end := bytes.IndexByte(m.b[offset:], '\n')
+		if end == -1 {
+			end = len(m.b)
+		} else {
+			end += offset
+		}
+		textEnd := end
+		if textEnd > start && m.b[textEnd-1] == '\r' {
+			textEnd--
+		}
+		prefix := m.paint(colorPrefix, fmt.Sprintf("%s:%d:%d", fileName, line, col))
+		if _, err := fmt.Fprintf(m.out, "%s:%s%s\n", prefix, marker, m.highlight(start, textEnd, sub)); err != nil {
+			return err
+		}
+		if end+1 >= rng.End.Byte {
+			return nil
+		}
+		offset, line, col, marker = end+1, line+1, 1, ""
+	}
+}
+
+// column returns the 1-based byte column of the position, as expected by the editors.
+func (m *Matcher) column(pos hcl.Pos) int {
+	return pos.Byte - (bytes.LastIndexByte(m.b[:pos.Byte], '\n') + 1) + 1
+}
+
+func (m *Matcher) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(m.out)
+	// Keep the HCL operators (e.g. ">=", "&&") readable in the output
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// substitutionText returns the source text of a recorded wildcard value. For the name or index of a traverser,
+// it returns the name or the index key alone. For a list, it returns the source text between its first and last
+// element, or the space separated strings (e.g. the block labels).
+func (m *Matcher) substitutionText(val substitution) string {
+	switch {
+	case val.String != nil:
+		return *val.String
+	case val.List != nil:
+		if isStringList(val) {
+			texts := make([]string, 0, len(val.List))
+			for _, elem := range val.List {
+				texts = append(texts, m.substitutionText(elem))
+			}
+			return strings.Join(texts, " ")
+		}
+		// The steps of a traversal don't start with the dot, e.g. "foo[0]" instead of ".foo[0]"
+		if first := val.List[0].Traverser; first != nil {
+			if _, ok := (*first).(hcl.TraverseAttr); ok {
+				return strings.TrimPrefix(string(substitutionRange(val).SliceBytes(m.b)), ".")
+			}
+		}
+		// The attributes/blocks that are not adjacent in the body (e.g. the remaining ones of an unordered body)
+		if !m.adjacentInBody(val.List) {
+			texts := make([]string, 0, len(val.List))
+			for _, elem := range val.List {
+				texts = append(texts, m.substitutionText(elem))
+			}
+			return strings.Join(texts, "\n")
+		}
+	case val.Traverser != nil:
+		switch trav := (*val.Traverser).(type) {
+		case hcl.TraverseRoot:
+			return trav.Name
+		case hcl.TraverseAttr:
+			return trav.Name
+		case hcl.TraverseIndex:
+			text := string(trav.SrcRange.SliceBytes(m.b))
+			return strings.TrimSuffix(strings.TrimPrefix(text, "["), "]")
+		}
+	}
+	return string(substitutionRange(val).SliceBytes(m.b))
+}
+
+// adjacentInBody reports whether the values are either not attributes/blocks, or the adjacent attributes/blocks in
+// the same body in the source order.
+func (m *Matcher) adjacentInBody(vals []substitution) bool {
+	if vals[0].Node == nil {
+		return true
+	}
+	body, ok := m.parentOf(vals[0].Node).(*hclsyntax.Body)
+	if !ok {
+		return true
+	}
+	elts := sortBody(body)
+	for i, elt := range elts {
+		if elt != vals[0].Node {
+			continue
+		}
+		if len(elts)-i < len(vals) {
+			return false
+		}
+		for j, val := range vals {
+			if elts[i+j] != val.Node {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isStringList reports whether the recorded wildcard value is a list of strings (e.g. the block labels), or an empty
+// list.
+func isStringList(val substitution) bool {
+	return val.List != nil && (len(val.List) == 0 || val.List[0].String != nil)
+}
+
+// hasSubstitutionRange reports whether the recorded wildcard value has a source range, which is neither a string
+// without a range (e.g. the variable of a for expression) nor an empty list.
+func hasSubstitutionRange(val substitution) bool {
+	switch {
+	case val.String != nil:
+		return val.StringRange != nil
+	case val.List != nil:
+		return len(val.List) != 0 && hasSubstitutionRange(val.List[0])
+	default:
+		return true
+	}
+}
+
+// substitutionRange returns the source range of a recorded wildcard value, which has a range.
+func substitutionRange(val substitution) hcl.Range {
+	switch {
+	case val.String != nil:
+		return *val.StringRange
+	case val.List != nil:
+		return hcl.RangeBetween(substitutionRange(val.List[0]), substitutionRange(val.List[len(val.List)-1]))
+	case val.Node != nil:
+		return val.Node.Range()
+	case val.ObjectConsItem != nil:
+		return hcl.RangeBetween(val.ObjectConsItem.KeyExpr.Range(), val.ObjectConsItem.ValueExpr.Range())
+	case val.Traverser != nil:
+		return (*val.Traverser).SourceRange()
+	default:
+		panic("never reach here")
+	}
+}
+
+// nodeTypeName returns the name of the hclsyntax type of the node, e.g. "Attribute".
+func nodeTypeName(node hclsyntax.Node) string {
+	return reflect.Indirect(reflect.ValueOf(node)).Type().Name()
+}
+
+// relativeFileName returns the file name relative to the working directory, if it resides in it.
+func relativeFileName(fileName string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return fileName
+	}
+	return relativeTo(wd, fileName)
+}
+
+// relativeTo returns the absolute file name relative to the directory, if it resides in it.
+func relativeTo(dir, fileName string) string {
+	if !filepath.IsAbs(fileName) {
+		return fileName
+	}
+	rel, err := filepath.Rel(dir, fileName)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fileName
+	}
+	return rel
+}
@@ -0,0 +1,508 @@
+package hclgrep
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// CmdValueTemplate is a piece of HCL code, whose wildcards are substituted by the recorded wildcard values.
+type CmdValueTemplate struct {
+	src       string
+	wildcards []templateWildcard
+}
+
+func (v CmdValueTemplate) Value() interface{} { return v }
+
+type templateWildcard struct {
+	name       string
+	start, end int
+}
+
+func parseTemplate(src string) (CmdValueTemplate, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return CmdValueTemplate{}, fmt.Errorf("cannot tokenize template: %v", err)
+	}
+	tmpl := CmdValueTemplate{src: src}
+	for _, tok := range toks {
+		switch tok.Type {
+		case hclsyntax.TokenType(TokenWildcard),
+			hclsyntax.TokenType(TokenWildcardAny),
+			hclsyntax.TokenType(TokenAttrWildcard),
+			hclsyntax.TokenType(TokenAttrWildcardAny):
+			name := string(tok.Bytes)
+			if name == "_" {
+				return CmdValueTemplate{}, fmt.Errorf("%v: wildcard %q can't be used in template", tok.Range, "_")
+			}
+			if tok.WildcardType != "" {
+				return CmdValueTemplate{}, fmt.Errorf("%v: wildcard type can't be used in template", tok.Range)
+			}
+			tmpl.wildcards = append(tmpl.wildcards, templateWildcard{
+				name:  name,
+				start: tok.Range.Start.Byte,
+				end:   tok.Range.End.Byte,
+			})
+		case hclsyntax.TokenType(TokenWildcardDeep),
+			hclsyntax.TokenType(TokenAttrWildcardDeep):
+			return CmdValueTemplate{}, fmt.Errorf("%v: deep wildcard can't be used in template", tok.Range)
+		case hclsyntax.TokenType(TokenUnorderedMarker):
+			return CmdValueTemplate{}, fmt.Errorf("%v: unordered marker can't be used in template", tok.Range)
+		}
+	}
+	return tmpl, nil
+}
+
+// expandTemplate substitutes the wildcards of the template by the recorded wildcard values.
+func (m *Matcher) expandTemplate(tmpl CmdValueTemplate, values map[string]substitution) (string, error) {
+	var buf strings.Builder
+	var last int
+	for _, wc := range tmpl.wildcards {
+		val, ok := values[wc.name]
+		if !ok {
+			return "", fmt.Errorf("wildcard %q in the template is not recorded", wc.name)
+		}
+		buf.WriteString(tmpl.src[last:wc.start])
+		text := m.templateText(val)
+		buf.WriteString(text)
+		last = wc.end
+		// The closing marker of a heredoc must be followed by a newline
+		if rest := tmpl.src[last:]; strings.HasPrefix(text, "<<") && !strings.HasSuffix(text, "\n") && rest != "" && !strings.HasPrefix(rest, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	buf.WriteString(tmpl.src[last:])
+	return buf.String(), nil
+}
+
+// templateText returns the text of a recorded wildcard value to expand in a template. Unlike substitutionText, the
+// strings with a source range (e.g. the block labels) are expanded as written, so that the quoted ones stay quoted.
+func (m *Matcher) templateText(val substitution) string {
+	switch {
+	case val.String != nil && val.StringRange != nil:
+		return string(val.StringRange.SliceBytes(m.b))
+	case isStringList(val):
+		texts := make([]string, 0, len(val.List))
+		for _, elem := range val.List {
+			texts = append(texts, m.templateText(elem))
+		}
+		return strings.Join(texts, " ")
+	}
+	return m.substitutionText(val)
+}
+
+// edit replaces the source in the byte range [start, end) with the text.
+type edit struct {
+	start, end int
+	text       string
+
+	// the byte range of the attribute or block that encloses the edit, which is to be formatted after the edit
+	fmtStart, fmtEnd int
+
+	// whether not format the edit
+	noFmt bool
+}
+
+// applyEdits applies the edits to the source. An edit overlapping with a preceding edit is ignored, e.g. the edit
+// for a match nested inside another match. It returns the edited source, together with the byte ranges in it that
+// need to be formatted, which are only the ones enclosing the edits that actually change the source.
+func applyEdits(src []byte, edits []edit) ([]byte, [][2]int) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start < edits[j].start
+		}
+		return edits[i].end > edits[j].end
+	})
+	var (
+		buf     bytes.Buffer
+		last    int
+		applied []edit
+	)
+	for _, e := range edits {
+		if e.start < last {
+			continue
+		}
+		buf.Write(src[last:e.start])
+		buf.WriteString(e.text)
+		last = e.end
+		applied = append(applied, e)
+	}
+	buf.Write(src[last:])
+
+	// mapOffset maps an offset of the source, which is not inside any edit, to the offset of the edited source.
+	// The text inserted at the offset is regarded to be after the offset if it is the start of a range, otherwise
+	// it is regarded to be before the offset.
+	mapOffset := func(offset int, isStart bool) int {
+		out := offset
+		for _, e := range applied {
+			if e.end < offset || e.end == offset && !(isStart && e.start == e.end) {
+				out += len(e.text) - (e.end - e.start)
+			}
+		}
+		return out
+	}
+	var fmtRanges [][2]int
+	for _, e := range applied {
+		if e.noFmt || string(src[e.start:e.end]) == e.text {
+			continue
+		}
+		fmtRanges = append(fmtRanges, [2]int{mapOffset(e.fmtStart, true), mapOffset(e.fmtEnd, false)})
+	}
+	return buf.Bytes(), fmtRanges
+}
+
+// formatMu serializes the calls of hclwrite.Format, which writes a shared token when formatting, as the files can be
+// rewritten concurrently.
+var formatMu sync.Mutex
+
+// formatRanges formats the lines that overlap with the byte ranges via hclwrite, while keeping the other lines
+// untouched.
+func formatRanges(src []byte, ranges [][2]int) []byte {
+	if len(ranges) == 0 {
+		return src
+	}
+	lines := splitLines(src)
+	formatMu.Lock()
+	formatted := hclwrite.Format(src)
+	formatMu.Unlock()
+	fmtLines := splitLines(formatted)
+	if len(lines) != len(fmtLines) {
+		// This shall not happen as formatting only changes the spaces in lines
+		return src
+	}
+
+	var lineStarts []int
+	var offset int
+	for _, line := range lines {
+		lineStarts = append(lineStarts, offset)
+		offset += len(line)
+	}
+	lineOf := func(offset int) int {
+		return sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > offset }) - 1
+	}
+
+	for _, rng := range ranges {
+		start, end := lineOf(rng[0]), lineOf(rng[1])
+		if rng[1] > rng[0] {
+			end = lineOf(rng[1] - 1)
+		}
+		for i := start; i <= end && i < len(lines); i++ {
+			lines[i] = fmtLines[i]
+		}
+	}
+	return bytes.Join(lines, nil)
+}
+
+// enclosingStatement returns the nearest attribute or block that encloses the node (including itself).
+func (m *Matcher) enclosingStatement(node hclsyntax.Node) hclsyntax.Node {
+	for ; node != nil; node = m.parentOf(node) {
+		switch node.(type) {
+		case *hclsyntax.Attribute, *hclsyntax.Block:
+			return node
+		}
+	}
+	return nil
+}
+
+// nodeEdit returns the edit that replaces the node with the text, which formats the enclosing statement of the
+// node.
+func (m *Matcher) nodeEdit(node hclsyntax.Node, text string) edit {
+	rng := node.Range()
+	e := edit{start: rng.Start.Byte, end: rng.End.Byte, text: text, fmtStart: rng.Start.Byte, fmtEnd: rng.End.Byte}
+	if stmt := m.enclosingStatement(node); stmt != nil {
+		e.fmtStart, e.fmtEnd = stmt.Range().Start.Byte, stmt.Range().End.Byte
+	}
+	return e
+}
+
+// deleteEdits returns the edits that delete the attribute, block or the object items of the submatch. The object
+// items are the ones of the matched object, which are recorded by the named attribute wildcards of the pattern.
+func (m *Matcher) deleteEdits(sub submatch) ([]edit, error) {
+	switch node := sub.node.(type) {
+	case *hclsyntax.Attribute, *hclsyntax.Block:
+		return []edit{m.deleteEdit(node.Range().Start.Byte, node.Range().End.Byte)}, nil
+	case *hclsyntax.ObjectConsExpr:
+		if edits := m.objectConsItemsDeleteEdits(node, sub.values); len(edits) != 0 {
+			return edits, nil
+		}
+	}
+	rng := sub.node.Range()
+	return nil, fmt.Errorf("%s: can't delete %s, only attributes, blocks and the recorded items of objects can be deleted", &rng, nodeTypeName(sub.node))
+}
+
+// objectConsItemsDeleteEdits returns the edits that delete the items of the object which are recorded in the values.
+// Each run of the adjacent items is deleted by one edit, together with the separating comma.
+func (m *Matcher) objectConsItemsDeleteEdits(obj *hclsyntax.ObjectConsExpr, values map[string]substitution) []edit {
+	keys := map[hclsyntax.Expression]bool{}
+	var record func(val substitution)
+	record = func(val substitution) {
+		switch {
+		case val.ObjectConsItem != nil:
+			keys[val.ObjectConsItem.KeyExpr] = true
+		case val.List != nil:
+			for _, elem := range val.List {
+				record(elem)
+			}
+		}
+	}
+	for _, val := range values {
+		record(val)
+	}
+
+	var edits []edit
+	for i := 0; i < len(obj.Items); i++ {
+		if !keys[obj.Items[i].KeyExpr] {
+			continue
+		}
+		j := i
+		for j+1 < len(obj.Items) && keys[obj.Items[j+1].KeyExpr] {
+			j++
+		}
+		start, end := obj.Items[i].KeyExpr.Range().Start.Byte, obj.Items[j].ValueExpr.Range().End.Byte
+		if k := skipSpaces(m.b, end); k < len(m.b) && m.b[k] == ',' {
+			end = skipSpaces(m.b, k+1)
+		} else if k := skipSpacesBackward(m.b, start); k > 0 && m.b[k-1] == ',' {
+			start = k - 1
+		}
+		edits = append(edits, m.deleteEdit(start, end))
+		i = j
+	}
+	return edits
+}
+
+// deleteEdit returns the edit that deletes the range. The line is removed if it becomes blank, together with the
+// comment at the end of it.
+func (m *Matcher) deleteEdit(start, end int) edit {
+	lineStart := skipSpacesBackward(m.b, start)
+	if lineStart > 0 && m.b[lineStart-1] != '\n' {
+		return edit{start: start, end: end, noFmt: true}
+	}
+	lineEnd := skipSpaces(m.b, end)
+	if rest := m.b[lineEnd:]; bytes.HasPrefix(rest, []byte("#")) || bytes.HasPrefix(rest, []byte("//")) {
+		if i := bytes.IndexByte(rest, '\n'); i != -1 {
+			lineEnd += i
+		} else {
+			lineEnd = len(m.b)
+		}
+	}
+	switch {
+	case lineEnd == len(m.b):
+	case m.b[lineEnd] == '\n':
+		lineEnd++
+	case bytes.HasPrefix(m.b[lineEnd:], []byte("\r\n")):
+		lineEnd += 2
+	default:
+		return edit{start: start, end: end, noFmt: true}
+	}
+	return edit{start: lineStart, end: lineEnd, noFmt: true}
+}
+
+// insertEdits returns the edits that insert the attributes and blocks of the snippet at the start (or the end, if
+// atEnd is true) of the body of the block. The attributes whose names already exist in the body are skipped, and the
+// blocks of the same type and labels as an existing block are merged into it in the same way. It returns no edit if
+// there is nothing to insert.
+func (m *Matcher) insertEdits(node hclsyntax.Node, snippet string, atEnd bool) ([]edit, error) {
+	blk, ok := node.(*hclsyntax.Block)
+	if !ok {
+		rng := node.Range()
+		return nil, fmt.Errorf("%s: can't insert into %s, only blocks can be inserted into", &rng, nodeTypeName(node))
+	}
+
+	f, diags := hclsyntax.ParseConfig([]byte(snippet), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("cannot parse snippet: %s", diags.Error())
+	}
+	return m.mergeEdits(blk, f.Body.(*hclsyntax.Body), []byte(snippet), atEnd), nil
+}
+
+// mergeEdits returns the edits that merge the body of the snippet into the block, as described in insertEdits.
+func (m *Matcher) mergeEdits(blk *hclsyntax.Block, body *hclsyntax.Body, snippet []byte, atEnd bool) []edit {
+	var (
+		elts   []string
+		nested []edit
+	)
+	for _, elt := range sortBody(body) {
+		switch elt := elt.(type) {
+		case *hclsyntax.Attribute:
+			if _, ok := blk.Body.Attributes[elt.Name]; ok {
+				continue
+			}
+		case *hclsyntax.Block:
+			if existing := m.sameBlock(blk.Body, elt); existing != nil {
+				nested = append(nested, m.mergeEdits(existing, elt.Body, snippet, atEnd)...)
+				continue
+			}
+		}
+		elts = append(elts, string(elt.Range().SliceBytes(snippet)))
+	}
+	if len(elts) == 0 {
+		return nested
+	}
+	text := strings.Join(elts, "\n")
+
+	open, close := blk.OpenBraceRange.End.Byte, blk.CloseBraceRange.Start.Byte
+	if blk.OpenBraceRange.Start.Line == blk.CloseBraceRange.Start.Line {
+		// Expand the single line block to multiple lines, which has no nested block to merge into
+		var lines []string
+		if inner := strings.TrimSpace(string(m.b[open:close])); inner != "" {
+			lines = []string{inner}
+		}
+		if atEnd {
+			lines = append(lines, text)
+		} else {
+			lines = append([]string{text}, lines...)
+		}
+		rng := blk.Range()
+		return []edit{{
+			start:    open,
+			end:      close,
+			text:     "\n" + strings.Join(lines, "\n") + "\n",
+			fmtStart: rng.Start.Byte,
+			fmtEnd:   rng.End.Byte,
+		}}
+	}
+
+	var pos int
+	if atEnd {
+		pos = skipSpacesBackward(m.b, close)
+		if pos > 0 && m.b[pos-1] != '\n' {
+			// There is other content before the closing brace in the same line
+			text = "\n" + text
+			pos = close
+		}
+	} else {
+		pos = bytes.IndexByte(m.b[open:], '\n') + open + 1
+	}
+	return append(nested, edit{start: pos, end: pos, text: text + "\n", fmtStart: pos, fmtEnd: pos})
+}
+
+// sameBlock returns the block of the body that has the same type and labels as the block, preferring the one that
+// is equal to it. It returns nil if there is no such block.
+func (m *Matcher) sameBlock(body *hclsyntax.Body, blk *hclsyntax.Block) *hclsyntax.Block {
+	values := m.values
+	defer func() { m.values = values }()
+	var same *hclsyntax.Block
+	for _, existing := range body.Blocks {
+		if existing.Type != blk.Type || !reflect.DeepEqual(existing.Labels, blk.Labels) {
+			continue
+		}
+		m.values = map[string]substitution{}
+		if m.node(blk, existing) {
+			return existing
+		}
+		if same == nil {
+			same = existing
+		}
+	}
+	return same
+}
+
+// skipSpaces returns the offset of the first non-space (excluding newline) byte since the offset.
+func skipSpaces(b []byte, offset int) int {
+	for offset < len(b) && (b[offset] == ' ' || b[offset] == '\t') {
+		offset++
+	}
+	return offset
+}
+
+// skipSpacesBackward returns the offset after the last non-space (excluding newline) byte before the offset.
+func skipSpacesBackward(b []byte, offset int) int {
+	for offset > 0 && (b[offset-1] == ' ' || b[offset-1] == '\t') {
+		offset--
+	}
+	return offset
+}
+
+// rewrite runs the terminal rewriting command against the final submatches, and outputs the rewritten file.
+// Only the attributes or blocks that are changed are formatted, the other content is kept as is.
+func (m *Matcher) rewrite(cmd Cmd, subs []submatch) error {
+	var edits []edit
+	switch cmd.name {
+	case CmdNameSubst:
+		tmpl := cmd.value.Value().(CmdValueTemplate)
+		for _, sub := range subs {
+			text, err := m.expandTemplate(tmpl, sub.values)
+			if err != nil {
+				return err
+			}
+			edits = append(edits, m.nodeEdit(sub.node, text))
+		}
+	case CmdNameDelete:
+		for _, sub := range subs {
+			es, err := m.deleteEdits(sub)
+			if err != nil {
+				return err
+			}
+			edits = append(edits, es...)
+		}
+	case CmdNameInsertBody, CmdNameAppend:
+		tmpl := cmd.value.Value().(CmdValueTemplate)
+		for _, sub := range subs {
+			text, err := m.expandTemplate(tmpl, sub.values)
+			if err != nil {
+				return err
+			}
+			es, err := m.insertEdits(sub.node, text, cmd.name == CmdNameAppend)
+			if err != nil {
+				return err
+			}
+			edits = append(edits, es...)
+		}
+	default:
+		panic(fmt.Sprintf("unknown rewrite command: %q", cmd.name))
+	}
+	b := formatRanges(applyEdits(m.b, edits))
+	// A template that doesn't fit in the position of the match, e.g. an attribute substituting an expression, makes
+	// the rewritten file invalid, which is never output.
+	if _, diags := hclsyntax.ParseConfig(b, m.fileName, hcl.InitialPos); diags.HasErrors() {
+		return fmt.Errorf("the rewritten file is invalid: %s", diags.Error())
+	}
+	return m.writeRewrite(b)
+}
+
+// writeRewrite outputs the rewritten file. By default, the rewritten file is written to the matcher's out.
+// Otherwise, the file is modified in place, and/or the diff is written to the matcher's out.
+func (m *Matcher) writeRewrite(b []byte) error {
+	if !m.rewriteWrite && !m.rewriteDiff {
+		_, err := m.out.Write(b)
+		return err
+	}
+	if m.rewriteDiff {
+		if err := writeUnifiedDiff(m.out, relativeFileName(m.fileName), m.b, b); err != nil {
+			return err
+		}
+	}
+	if m.rewriteWrite && !bytes.Equal(m.b, b) {
+		fileName := m.fileName
+		write := func() error {
+			info, err := os.Stat(fileName)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(fileName, b, info.Mode().Perm())
+		}
+		if m.deferredWrite != nil {
+			*m.deferredWrite = write
+			return nil
+		}
+		return write()
+	}
+	return nil
+}
+
+func isRewriteCmd(name CmdName) bool {
+	switch name {
+	case CmdNameSubst, CmdNameDelete, CmdNameInsertBody, CmdNameAppend:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,266 @@
+package hclgrep
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+func TestRewriteWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tf": "a = var.x[count.index]\n",
+		"b.tf": "b = 1\n",
+	})
+
+	opts, _, err := ParseArgs([]string{"-write", "-diff", "-x", "var.$v[count.index]", "-s", "var.$v[0]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBufferString("")
+	m := NewMatcher(append(opts, OptionOutput(buf))...)
+	if _, err := m.Files([]string{dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	aFile := filepath.Join(dir, "a.tf")
+	wantDiff := "--- " + aFile + "\n+++ " + aFile + "\n@@ -1 +1 @@\n-a = var.x[count.index]\n+a = var.x[0]\n"
+	if got := buf.String(); got != wantDiff {
+		t.Fatalf("wanted diff:\n%s\ngot:\n%s\n", wantDiff, got)
+	}
+	for f, want := range map[string]string{
+		"a.tf": "a = var.x[0]\n",
+		"b.tf": "b = 1\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != want {
+			t.Fatalf("%s: wanted:\n%s\ngot:\n%s\n", f, want, got)
+		}
+	}
+}
+
+func TestRewriteMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tf": "a = 1\n",
+		"b.tf": "b = 1\n",
+	})
+
+	opts, _, err := ParseArgs([]string{"-x", "a = $_", "-s", "a = 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBufferString("")
+	m := NewMatcher(append(opts, OptionOutput(buf))...)
+	if _, err := m.Files([]string{dir}); err == nil {
+		t.Fatal("wanted error, got none")
+	}
+	if got := buf.String(); got != "" {
+		t.Fatalf("wanted no output, got:\n%s\n", got)
+	}
+
+	// a single file is printed
+	if _, err := m.Files([]string{filepath.Join(dir, "a.tf")}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "a = 3\n"; got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s\n", want, got)
+	}
+}
+
+func TestRewriteWriteStrict(t *testing.T) {
+	for _, jobs := range []int{1, 8} {
+		dir := t.TempDir()
+		files := map[string]string{}
+		for i := 1; i <= 40; i++ {
+			files[fmt.Sprintf("f%02d.tf", i)] = "a = 1\n"
+		}
+		files["f05.tf"] = "a = \n"
+		writeFiles(t, dir, files)
+
+		opts, _, err := ParseArgs([]string{"-strict", "-write", "-x", "a = 1", "-s", "a = 2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewMatcher(append(opts, OptionJobs(jobs))...)
+		if _, err := m.Files([]string{dir}); err == nil {
+			t.Fatalf("-j %d: wanted error, got none", jobs)
+		}
+		// none of the files after the failed one is modified
+		for i := 1; i <= 40; i++ {
+			f := fmt.Sprintf("f%02d.tf", i)
+			b, err := os.ReadFile(filepath.Join(dir, f))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "a = 1\n"
+			switch {
+			case i < 5:
+				want = "a = 2\n"
+			case i == 5:
+				want = "a = \n"
+			}
+			if got := string(b); got != want {
+				t.Fatalf("-j %d: %s: wanted:\n%s\ngot:\n%s\n", jobs, f, want, got)
+			}
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		old, new string
+		want     string
+	}{
+		{"a\n", "a\n", ""},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"1\n2\nx\n4\n5\n6\n7\n8\n9\n10\ny\n12\n",
+			"--- a/f\n+++ b/f\n@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+x\n 4\n 5\n 6\n@@ -8,5 +8,5 @@\n 8\n 9\n 10\n-11\n+y\n 12\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n",
+			"1\n2\n4\n5\nx\n",
+			"--- a/f\n+++ b/f\n@@ -1,5 +1,5 @@\n 1\n 2\n-3\n 4\n 5\n+x\n",
+		},
+		{
+			"a\nb",
+			"a\nc",
+			"--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			"",
+			"a\n",
+			"--- a/f\n+++ b/f\n@@ -0,0 +1 @@\n+a\n",
+		},
+	}
+	for i, tc := range tests {
+		buf := bytes.NewBufferString("")
+		if err := writeUnifiedDiff(buf, "f", []byte(tc.old), []byte(tc.new)); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Fatalf("%d: wanted:\n%s\ngot:\n%s\n", i, tc.want, got)
+		}
+	}
+}
+
+func rewriteStr(t *testing.T, args []string, src string) string {
+	opts, _, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("%v: unexpected error: %v", args, err)
+	}
+	buf := bytes.NewBufferString("")
+	m := NewMatcher(append(opts, OptionOutput(buf))...)
+	if err := m.File("", bytes.NewBufferString(src)); err != nil {
+		t.Fatalf("%v | %s: unexpected error: %v", args, src, err)
+	}
+	return buf.String()
+}
+
+// roundTripTest rewrites all the attributes of a formatted config, then rewrites them back, which shall result in
+// the same config.
+func roundTripTest(t *testing.T, src string) {
+	if _, diags := hclsyntax.ParseConfig([]byte(src), "", hcl.InitialPos); diags.HasErrors() {
+		return
+	}
+	if !bytes.Equal(hclwrite.Format([]byte(src)), []byte(src)) {
+		return
+	}
+	rewritten := rewriteStr(t, []string{"-x", "$n = $v", "-s", "$n = roundtrip($v)"}, src)
+	got := rewriteStr(t, []string{"-x", "$n = roundtrip($v)", "-s", "$n = $v"}, rewritten)
+	if got != src {
+		t.Fatalf("round trip of:\n%s\nrewritten:\n%s\ngot:\n%s\n", src, rewritten, got)
+	}
+}
+
+func TestRewriteFormat(t *testing.T) {
+	tests := []struct {
+		args []string
+		src  string
+		want string
+	}{
+		// comments and alignment are kept
+		{
+			args: []string{"-x", "var.$v[count.index]", "-s", "var.$v[0]"},
+			src: `# leading comment
+locals {
+  # the name
+  name    = var.x[count.index] # trailing comment
+  another = 1 /* inline comment */
+}
+`,
+			want: `# leading comment
+locals {
+  # the name
+  name    = var.x[0] # trailing comment
+  another = 1 /* inline comment */
+}
+`,
+		},
+		// only the changed attribute is formatted
+		{
+			args: []string{"-x", "foo = $v", "-s", "foo    =    [ $v ]"},
+			src: `blk {
+    foo = 1
+  bar   =   2
+}
+`,
+			want: `blk {
+  foo = [1]
+  bar   =   2
+}
+`,
+		},
+		// the changed attribute is aligned with its siblings
+		{
+			args: []string{"-x", "name = $v", "-s", "name = upper($v)"},
+			src: `name      = "a"
+long_name = "b"
+`,
+			want: `name      = upper("a")
+long_name = "b"
+`,
+		},
+		// the changed multi-line block is formatted
+		{
+			args: []string{"-x", "blk $l {@*_}", "-s", "blk $l {\nfoo = {\na = 1\n}\n}"},
+			src: `other {
+   untouched = 1
+}
+blk "x" {
+}
+`,
+			want: `other {
+   untouched = 1
+}
+blk "x" {
+  foo = {
+    a = 1
+  }
+}
+`,
+		},
+		// the unchanged match is not formatted
+		{
+			args: []string{"-x", "[$v]", "-s", "[$v]"},
+			src:  "foo   =   [1]\n",
+			want: "foo   =   [1]\n",
+		},
+	}
+
+	for i, tc := range tests {
+		if got := rewriteStr(t, tc.args, tc.src); got != tc.want {
+			t.Fatalf("%d: wanted:\n%s\ngot:\n%s\n", i, tc.want, got)
+		}
+	}
+}
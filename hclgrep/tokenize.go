@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"strings"
 )
 
 // exprTokenType exists to add extra possible tokens on top of the ones
@@ -18,12 +19,17 @@ const (
 	TokenWildcardAny
 	TokenAttrWildcard
 	TokenAttrWildcardAny
+	TokenWildcardDeep
+	TokenAttrWildcardDeep
+	TokenUnorderedMarker
 )
 
 type fullToken struct {
 	Type  hclsyntax.TokenType
 	Bytes []byte
 	Range hcl.Range
+	// the type of the expression wildcard, e.g. "string" of $x:string
+	WildcardType string
 }
 
 type fullTokens []fullToken
@@ -43,6 +49,10 @@ func tokenize(src string) (fullTokens, error) {
 		if tok := string(diag.Subject.SliceBytes([]byte(src))); diag.Summary == "Invalid character" && (tok == wildcardLit || tok == attrWildcardLit) {
 			continue
 		}
+		// The bitwise operators are reported when parsing the pattern, so that "~" can be used in the unordered marker
+		if diag.Summary == "Unsupported operator" {
+			continue
+		}
 		diags = diags.Append(diag)
 	}
 	if diags.HasErrors() {
@@ -55,7 +65,7 @@ func tokenize(src string) (fullTokens, error) {
 
 	var remaining []fullToken
 	for _, tok := range tokens[start:] {
-		remaining = append(remaining, fullToken{tok.Type, tok.Bytes, tok.Range})
+		remaining = append(remaining, fullToken{Type: tok.Type, Bytes: tok.Bytes, Range: tok.Range})
 		if tok.Type == hclsyntax.TokenEOF {
 			break
 		}
@@ -86,6 +96,7 @@ func tokenize(src string) (fullTokens, error) {
 			t = next()
 			continue
 		}
+		wildcardRange := t.Range
 		switch string(t.Bytes) {
 		case wildcardLit:
 			wildcardTokenType = hclsyntax.TokenType(TokenWildcard)
@@ -95,6 +106,35 @@ func tokenize(src string) (fullTokens, error) {
 			panic("never reach here")
 		}
 		t = next()
+		if wildcardTokenType == hclsyntax.TokenType(TokenAttrWildcard) && t.Type == hclsyntax.TokenBitwiseNot {
+			toks = append(toks, fullToken{
+				Type:  hclsyntax.TokenType(TokenUnorderedMarker),
+				Range: hcl.RangeBetween(wildcardRange, t.Range),
+			})
+			t = next()
+			continue
+		}
+		if string(t.Bytes) == string(hclsyntax.TokenStar) && len(remaining) > 0 && string(remaining[0].Bytes) == string(hclsyntax.TokenStar) {
+			// The deep wildcard is followed by the nested pattern, i.e. $**(expression) or @**{body}
+			open := hclsyntax.TokenOParen
+			if wildcardTokenType == hclsyntax.TokenType(TokenAttrWildcard) {
+				open = hclsyntax.TokenOBrace
+				wildcardTokenType = hclsyntax.TokenType(TokenAttrWildcardDeep)
+			} else {
+				wildcardTokenType = hclsyntax.TokenType(TokenWildcardDeep)
+			}
+			star := next()
+			t = next()
+			if t.Type != open {
+				return nil, fmt.Errorf("%v: deep wildcard must be followed by %q, got %v",
+					t.Range, string(open), t.Type)
+			}
+			toks = append(toks, fullToken{
+				Type:  wildcardTokenType,
+				Range: hcl.RangeBetween(wildcardRange, star.Range),
+			})
+			continue
+		}
 		if string(t.Bytes) == string(hclsyntax.TokenStar) {
 			switch wildcardTokenType {
 			case hclsyntax.TokenType(TokenWildcard):
@@ -108,12 +148,36 @@ func tokenize(src string) (fullTokens, error) {
 			return nil, fmt.Errorf("%v: wildcard must be followed by ident, got %v",
 				t.Range, t.Type)
 		}
-		toks = append(toks, fullToken{
+		// The dash separates the name from the type or the index in the encoded wildcard
+		if bytes.ContainsRune(t.Bytes, '-') {
+			return nil, fmt.Errorf("%v: wildcard name can't contain %q, got %q", t.Range, "-", string(t.Bytes))
+		}
+		// The range of a wildcard token covers from the wildcard literal to the end of the name (or the type)
+		tok := fullToken{
 			Type:  wildcardTokenType,
 			Bytes: t.Bytes,
-			Range: t.Range,
-		})
+			Range: hcl.RangeBetween(wildcardRange, t.Range),
+		}
 		t = next()
+		// The type annotation of an expression wildcard must be adjacent, e.g. $x:string, to not be confused with
+		// the colon of a conditional or for expression
+		if len(remaining) > 0 && t.Type == hclsyntax.TokenColon && remaining[0].Type == hclsyntax.TokenIdent &&
+			t.Range.Start.Byte == tok.Range.End.Byte && remaining[0].Range.Start.Byte == t.Range.End.Byte {
+			typ := string(remaining[0].Bytes)
+			if _, ok := wildTypes[typ]; !ok {
+				return nil, fmt.Errorf("%v: unknown wildcard type %q (valid: %s)",
+					remaining[0].Range, typ, strings.Join(wildTypeNames(), ", "))
+			}
+			if wildcardTokenType != hclsyntax.TokenType(TokenWildcard) {
+				return nil, fmt.Errorf("%v: only the expression wildcard (excluding the any wildcard) can have a type, got %q",
+					remaining[0].Range, typ)
+			}
+			t = next()
+			tok.WildcardType = typ
+			tok.Range = hcl.RangeBetween(tok.Range, t.Range)
+			t = next()
+		}
+		toks = append(toks, tok)
 	}
 
 	return toks, nil
@@ -121,17 +185,27 @@ func tokenize(src string) (fullTokens, error) {
 
 func (toks fullTokens) Bytes() []byte {
 	var buf bytes.Buffer
+	attrCounters := map[string]int{}
 	for i, t := range toks {
 		var s string
 		switch {
+		case t.Type == hclsyntax.TokenType(TokenWildcard) && t.WildcardType != "":
+			s = wildTypedName(string(t.Bytes), t.WildcardType)
 		case t.Type == hclsyntax.TokenType(TokenWildcard):
 			s = wildName(string(t.Bytes), false)
 		case t.Type == hclsyntax.TokenType(TokenWildcardAny):
 			s = wildName(string(t.Bytes), true)
 		case t.Type == hclsyntax.TokenType(TokenAttrWildcard):
-			s = wildAttr(string(t.Bytes), false)
+			s = wildAttr(string(t.Bytes), false, attrCounters[string(t.Bytes)])
+			attrCounters[string(t.Bytes)]++
 		case t.Type == hclsyntax.TokenType(TokenAttrWildcardAny):
-			s = wildAttr(string(t.Bytes), true)
+			s = wildAttr(string(t.Bytes), true, attrCounters[string(t.Bytes)])
+			attrCounters[string(t.Bytes)]++
+		case t.Type == hclsyntax.TokenType(TokenWildcardDeep),
+			t.Type == hclsyntax.TokenType(TokenAttrWildcardDeep):
+			s = deepWildcardName
+		case t.Type == hclsyntax.TokenType(TokenUnorderedMarker):
+			s = unorderedMarkerName + "=" + wildAttrValue
 		default:
 			s = string(t.Bytes)
 		}
@@ -143,7 +217,10 @@ func (toks fullTokens) Bytes() []byte {
 				peekTok.Type == hclsyntax.TokenType(TokenWildcard) ||
 				peekTok.Type == hclsyntax.TokenType(TokenAttrWildcard) ||
 				peekTok.Type == hclsyntax.TokenType(TokenWildcardAny) ||
-				peekTok.Type == hclsyntax.TokenType(TokenAttrWildcardAny) {
+				peekTok.Type == hclsyntax.TokenType(TokenAttrWildcardAny) ||
+				peekTok.Type == hclsyntax.TokenType(TokenWildcardDeep) ||
+				peekTok.Type == hclsyntax.TokenType(TokenAttrWildcardDeep) ||
+				peekTok.Type == hclsyntax.TokenType(TokenUnorderedMarker) {
 				buf.WriteByte(' ') // for e.g. consecutive idents (e.g. ForExpr)
 			}
 		}
@@ -3,16 +3,44 @@ package hclgrep
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 var usage = func() {
-	fmt.Fprintf(os.Stderr, `usage: hclgrep [options] commands [FILE...]
+	fmt.Fprintf(os.Stderr, `usage: hclgrep [options] commands [FILE|DIR...]
 
-hclgrep performs a query on the given HCL(v2) files.
+hclgrep performs a query on the given HCL(v2) files. Directories are walked
+recursively for files with the HCL extensions, skipping the hidden files and
+directories, and the ones ignored by the .gitignore, .ignore or .hclgrepignore files
+(including the ones in the parent directories, up to the root of the git repository).
+Files ending in ".json" are parsed in the JSON syntax of HCL.
 
 An option is one of the following:
 
     -H                  prefix the filename and byte offset of a match (defaults to "true" when reading from multiple files)
+    -n                  prefix the filename, line and column of a match on the same line as its first line
+    -vimgrep            print each line of a match, prefixed with the filename, line and column (e.g. for the quickfix list of vim)
+    -format template    print each match with the Go template, e.g. '{{.File}}:{{.Line}} {{.Captures.name}}' (fields: File, Line, Column, Start, End, Text, Type, Captures)
+    -color when         color the prefixes, the matches and the captured wildcard values: auto (only when stdout is a terminal), always or never (defaults to "auto")
+    -heading            print the breadcrumb path of the ancestor blocks above each match, e.g. "resource.aws_instance.web > network_interface"
+    -A  number          print the lines of the matches, with a number of lines after each match (groups are separated by "--")
+    -B  number          print the lines of the matches, with a number of lines before each match (groups are separated by "--")
+    -C  number          print the lines of the matches, with a number of lines before and after each match (groups are separated by "--")
+    -json               output each match (or each wildcard value printed by "-%s") as a JSON object
+    -include glob       only search the files matching the glob when walking directories (can be repeated)
+    -exclude glob       skip the files or directories matching the glob when walking directories (can be repeated)
+    -ext exts           comma separated file extensions to search when walking directories (defaults to "%s")
+    -hidden             search the hidden files and directories when walking directories
+    -no-ignore          don't respect the ignore files (.gitignore, .ignore, .hclgrepignore) when walking directories
+    -j  number          number of files to process concurrently, the output is still in the order of the files (defaults to 1, 0 means the number of CPUs)
+    -write              modify the files in place for the rewriting command, instead of printing the rewritten files
+    -diff               print the diff of the files for the rewriting command, instead of printing the rewritten files
+    -c                  print the number of matches of each file, and the total number if there are multiple files
+    -l                  print only the names of the files with matches
+    -L                  print only the names of the files without matches
+    -strict             stop at the first file that can't be parsed, instead of printing its diagnostics to stderr and continuing
+    -lenient            match the body recovered from the files with syntax errors (except for the JSON syntax), marking the matches overlapping any error with "(invalid)"
+    -unordered          match the attributes/blocks of the bodies in the patterns in any order (the remaining ones are matched by the first any wildcard)
 
 A command is one of the following:
 
@@ -20,8 +48,12 @@ A command is one of the following:
 	-%s  pattern         discard nodes not matching a pattern
 	-%s  pattern         discard nodes matching a pattern
 	-%s  number          navigate up a number of node parents
-	-%s name="regexp"   filter nodes by regexp against wildcard value of "name"
-	-%s  name            print the wildcard node only (must be the last command)
+	-%s name="regexp"   filter nodes by regexp against wildcard value of "name" (or "name.key", "name.value" for either side of an object element or an attribute)
+	-%s  names           print the wildcard values of comma separated names, tab separated on one line per node (the matches are only printed if it is not the last command)
+	-%s  template        substitute the nodes with a template, then print the rewritten file (must be the last command)
+	-%s             delete the attributes, blocks or object items (recorded in the matched object), then print the rewritten file (must be the last command)
+	-%s snippet insert the snippet at the start of the body of the blocks, then print the rewritten file (must be the last command)
+	-%s snippet     append the snippet at the end of the body of the blocks, then print the rewritten file (must be the last command)
 
 A pattern is a piece of HCL code which may include wildcards. It can be:
 
@@ -33,12 +65,30 @@ There are two types of wildcards can be used in a pattern, depending on the scop
 - Attribute wildcard ("@"): represents an attribute, a block or an object element
 - Expression wildcard ("$"): represents an expression or a place that a string is accepted (i.e. as a block type, block label)
 
-The wildcards are followed by a name. Each wildcard with the same name must match the same node/string, excluding "_". Example:
+The wildcards are followed by a name, which is an identifier without "-". Each wildcard with the same name must match the same node/string, excluding "_". Example:
 
     $x.$_ = $x # assignment of self to a field in self
 
+An expression wildcard can be followed by a type, with no space around the colon, to only match the expressions of
+the type:
+
+- string: a string without interpolation
+- number: a number, including the negative one
+- bool: true or false
+- traversal: a traversal, including the indexes and splats, e.g. var.foo[0]
+- call: a function call
+- tuple: a tuple constructor
+- object: an object constructor
+- template: a quoted string or a heredoc, with or without interpolation
+
+Example:
+
+    count = $n:number # a hardcoded count
+
 The wildcard name is only recorded for "-x" command or "-g" command (the first match in DFS).
 
+An object element recorded by an attribute wildcard is rendered as "key = value" by "-w" and "-rx".
+
 If "*" is before the name, it will match any number of nodes. Example:
 
     [$*_] # any number of elements in a tuple
@@ -46,5 +96,40 @@ If "*" is before the name, it will match any number of nodes. Example:
     resource foo "name" {
         @*_  # any number of attributes/blocks inside the resource block body
     }
-`, CmdNameMatch, CmdNameFilterMatch, CmdNameFilterUnMatch, CmdNameParent, CmdNameRx, CmdNameWrite)
+
+The nodes matched by an any wildcard with a name other than "_" are recorded as a list, which must be the same for each
+wildcard of the same name. The list is printed as the source text from its first to its last node (or the space
+separated block labels), and "-rx" matches it if any of its nodes matches. Example:
+
+    [$*x, 0, $*x] # a tuple with the same elements around 0
+
+    module.$*_.id # any number of steps in a traversal, including the indexes and splats, e.g. module.foo[0].id
+
+If "**" is before a nested pattern, it will match a node if the nested pattern matches the node itself or any node
+at any depth beneath it. The nested pattern of an expression wildcard ("$**") is an expression enclosed in parentheses,
+and the one of an attribute wildcard ("@**") is a body enclosed in braces, which matches one attribute or block. The
+wildcard values recorded by the nested pattern are the ones of the first match (in DFS). Example:
+
+    length($**(var.$_)) # any variable used in the argument of length()
+
+    resource $_ $_ {
+        for_each = $_
+        @**{ $_ = $**(count.index) } # any attribute at any depth using count.index
+    }
+
+A body with the "@~" marker is matched in any order (as with "-unordered"): each attribute or block of the pattern
+must match a distinct one of the body, and the remaining ones are matched by the first any wildcard, if there is any.
+Example:
+
+    resource $_ $_ {
+        @~
+        ami           = $_
+        instance_type = $_
+        @*_  # any other attributes/blocks, before, between or after them
+    }
+
+The exit status is 0 if anything is selected, 1 if nothing is selected, and 2 if an error occurred (including any
+file with syntax errors, even if it is searched with "-lenient"). Anything selected is a final match, a value printed by "-w", or a file name printed by
+"-L".
+`, CmdNameWrite, strings.Join(defaultExtensions, ","), CmdNameMatch, CmdNameFilterMatch, CmdNameFilterUnMatch, CmdNameParent, CmdNameRx, CmdNameWrite, CmdNameSubst, CmdNameDelete, CmdNameInsertBody, CmdNameAppend)
 }
@@ -0,0 +1,162 @@
+package hclgrep
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultExtensions are the file extensions of the files to be searched when walking a directory.
+var defaultExtensions = []string{".hcl", ".tf", ".tfvars", ".tfbackend", ".pkr.hcl", ".nomad", ".tf.json", ".tfvars.json", ".hcl.json"}
+
+// expandPaths expands the paths by walking the directories recursively, returning the files to be searched.
+// Files explicitly specified are always searched, while files found from the directories are filtered by the
+// hidden files rule, the ignore files, the extensions and the include/exclude glob patterns.
+func (m *Matcher) expandPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		// The directories are keyed by the paths as walked, which have no trailing slash
+		root = filepath.Clean(root)
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+		ignores := map[string]ignoreList{}
+		var parentIgnores ignoreList
+		if !m.noIgnore {
+			if parentIgnores, err = loadParentIgnoreFiles(root); err != nil {
+				return nil, err
+			}
+		}
+		err = filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, fpath)
+			if err != nil {
+				return err
+			}
+			if fpath != root {
+				if !m.hidden && strings.HasPrefix(d.Name(), ".") {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !m.noIgnore && ignores[filepath.Dir(fpath)].ignored(fpath, d.IsDir()) {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+			if d.IsDir() {
+				if fpath != root && m.excluded(rel) {
+					return filepath.SkipDir
+				}
+				if !m.noIgnore {
+					parent := ignores[filepath.Dir(fpath)]
+					if fpath == root {
+						parent = parentIgnores
+					}
+					ignores[fpath], err = parent.loadIgnoreFiles(fpath)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !m.hasExtension(d.Name()) || m.excluded(rel) || !m.included(rel) {
+				return nil
+			}
+			files = append(files, fpath)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// loadParentIgnoreFiles loads the ignore files of the parent directories of the root, up to the root of the git
+// repository that contains it. No ignore file of the parent directories is loaded if the root is not inside a git
+// repository. The rules are relative to the root, as the walked paths are.
+func loadParentIgnoreFiles(root string) (ignoreList, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for dir := abs; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+		dirs = append([]string{dir}, dirs...)
+	}
+
+	var l ignoreList
+	for _, dir := range dirs {
+		if l, err = l.loadIgnoreFiles(dir); err != nil {
+			return nil, err
+		}
+	}
+	for i := range l {
+		prefix, err := filepath.Rel(l[i].dir, abs)
+		if err != nil {
+			return nil, err
+		}
+		l[i].dir, l[i].prefix = root, filepath.ToSlash(prefix)
+	}
+	return l, nil
+}
+
+func (m *Matcher) hasExtension(name string) bool {
+	exts := m.extensions
+	if len(exts) == 0 {
+		exts = defaultExtensions
+	}
+	for _, ext := range exts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) included(rel string) bool {
+	if len(m.includes) == 0 {
+		return true
+	}
+	return matchAnyGlob(m.includes, rel)
+}
+
+func (m *Matcher) excluded(rel string) bool {
+	return matchAnyGlob(m.excludes, rel)
+}
+
+// matchAnyGlob tells whether the slash separated relative path, or its base name, matches any of the glob patterns.
+func matchAnyGlob(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package hclgrep
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for f, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func relFiles(dir string, files []string) []string {
+	var out []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f)
+		out = append(out, filepath.ToSlash(rel))
+	}
+	return out
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.tf":                  "",
+		"terraform.tfvars":         "",
+		"README.md":                "",
+		"packer/build.pkr.hcl":     "",
+		"jobs/web.nomad":           "",
+		"modules/foo/main.tf":      "",
+		"modules/foo/test/main.tf": "",
+	})
+
+	tests := []struct {
+		opts  []Option
+		paths []string
+		want  []string
+	}{
+		{
+			paths: []string{dir},
+			want: []string{
+				"jobs/web.nomad",
+				"main.tf",
+				"modules/foo/main.tf",
+				"modules/foo/test/main.tf",
+				"packer/build.pkr.hcl",
+				"terraform.tfvars",
+			},
+		},
+		// explicit files are not filtered
+		{
+			paths: []string{filepath.Join(dir, "README.md")},
+			want:  []string{"README.md"},
+		},
+		{
+			opts:  []Option{OptionExtensions([]string{".tf"})},
+			paths: []string{dir},
+			want: []string{
+				"main.tf",
+				"modules/foo/main.tf",
+				"modules/foo/test/main.tf",
+			},
+		},
+		{
+			opts:  []Option{OptionIncludes([]string{"modules/*/*.tf"})},
+			paths: []string{dir},
+			want: []string{
+				"modules/foo/main.tf",
+			},
+		},
+		{
+			opts:  []Option{OptionExcludes([]string{"test", "*.tfvars"})},
+			paths: []string{dir},
+			want: []string{
+				"jobs/web.nomad",
+				"main.tf",
+				"modules/foo/main.tf",
+				"packer/build.pkr.hcl",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		m := NewMatcher(tc.opts...)
+		files, err := m.expandPaths(tc.paths)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.paths, err)
+		}
+		got := relFiles(dir, files)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%v: wanted %v, got %v", tc.paths, tc.want, got)
+		}
+	}
+}
+
+func TestExpandPathsIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":                 "*.tfvars\n.terraform/\n",
+		"main.tf":                    "",
+		"prod.tfvars":                "",
+		".terraform.lock.hcl":        "",
+		".terraform/modules/a/a.tf":  "",
+		".github/b.hcl":              "",
+		"modules/.ignore":            "generated_*.tf\n!*.tfvars\n",
+		"modules/a/generated_foo.tf": "",
+		"modules/a/main.tf":          "",
+		"modules/a/test.tfvars":      "",
+		"vendor/x.tf":                "",
+		".hclgrepignore":             "/vendor\n",
+	})
+
+	tests := []struct {
+		opts []Option
+		want []string
+	}{
+		{
+			want: []string{
+				"main.tf",
+				"modules/a/main.tf",
+				"modules/a/test.tfvars",
+			},
+		},
+		{
+			opts: []Option{OptionHidden(true)},
+			want: []string{
+				".github/b.hcl",
+				".terraform.lock.hcl",
+				"main.tf",
+				"modules/a/main.tf",
+				"modules/a/test.tfvars",
+			},
+		},
+		{
+			opts: []Option{OptionNoIgnore(true)},
+			want: []string{
+				"main.tf",
+				"modules/a/generated_foo.tf",
+				"modules/a/main.tf",
+				"modules/a/test.tfvars",
+				"prod.tfvars",
+				"vendor/x.tf",
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		// the ignore files of the root are loaded regardless of the trailing slash
+		for _, root := range []string{dir, dir + string(filepath.Separator)} {
+			m := NewMatcher(tc.opts...)
+			files, err := m.expandPaths([]string{root})
+			if err != nil {
+				t.Fatalf("%d: %s: unexpected error: %v", i, root, err)
+			}
+			if got := relFiles(dir, files); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("%d: %s: wanted %v, got %v", i, root, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestExpandPathsParentIgnore(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		want  []string
+	}{
+		// the ignore files up to the root of the git repository are loaded
+		{
+			files: map[string]string{
+				".git/HEAD":                  "",
+				".gitignore":                 "*.tfvars\n/modules/a/generated.tf\n",
+				"modules/.ignore":            "test/\n",
+				"modules/a/main.tf":          "",
+				"modules/a/generated.tf":     "",
+				"modules/a/prod.tfvars":      "",
+				"modules/a/test/main.tf":     "",
+				"modules/a/sub/generated.tf": "",
+			},
+			want: []string{"main.tf", "sub/generated.tf"},
+		},
+		// no ignore file of the parent directories is loaded outside of a git repository
+		{
+			files: map[string]string{
+				".gitignore":            "*.tfvars\n",
+				"modules/a/main.tf":     "",
+				"modules/a/prod.tfvars": "",
+			},
+			want: []string{"main.tf", "prod.tfvars"},
+		},
+	}
+
+	for i, tc := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, tc.files)
+		root := filepath.Join(dir, "modules", "a")
+		m := NewMatcher()
+		files, err := m.expandPaths([]string{root})
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if got := relFiles(root, files); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%d: wanted %v, got %v", i, tc.want, got)
+		}
+	}
+}
@@ -8,18 +8,33 @@ import (
 	"os"
 )
 
+// Exit codes, as grep does
+const (
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
+)
+
 func main() {
 	opts, files, err := hclgrep.ParseArgs(os.Args[1:])
 	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
-			os.Exit(0)
+			os.Exit(exitMatch)
 		}
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 	m := hclgrep.NewMatcher(opts...)
-	if err := m.Files(files); err != nil {
+	res, err := m.Files(files)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitError)
+	}
+	// The files that have syntax errors are reported, even if they are searched in lenient mode
+	if res.FailedFiles != 0 || res.InvalidFiles != 0 {
+		os.Exit(exitError)
+	}
+	if res.SelectedFiles == 0 {
+		os.Exit(exitNoMatch)
 	}
 }